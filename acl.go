@@ -0,0 +1,367 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// accessList is the set of rules in .serve/acl that decide who on the
+// tailnet may see what. Each line is
+//
+//	allow|deny <path glob> <who>...
+//
+// where the glob's * matches within a path segment and ** matches any
+// number of segments, and each who is a login name pattern such as
+// alice@example.com or *@example.com, tag:name, node:name, or * for
+// everyone. The first rule matching both the path and the requester
+// decides; requests no rule matches are allowed. The file is re-read when
+// it changes.
+type accessList struct {
+	mu    sync.Mutex
+	file  string
+	size  int64
+	mod   time.Time
+	rules []aclRule
+}
+
+type aclRule struct {
+	allow   bool
+	pattern []string // path glob segments, lowercased
+	who     []string
+	line    int
+	text    string
+}
+
+func (r *aclRule) String() string {
+	return fmt.Sprintf("rule %d (%s)", r.line, r.text)
+}
+
+// siteACL holds the access rules in Tailscale mode, if .serve/acl exists.
+var siteACL *accessList
+
+// loadACL reads the access rules in file. It returns nil if there is no
+// such file.
+func loadACL(file string) (*accessList, error) {
+	a := &accessList{file: file}
+	info, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if a.rules, err = parseACL(string(content)); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	a.size, a.mod = info.Size(), info.ModTime()
+	return a, nil
+}
+
+func parseACL(content string) ([]aclRule, error) {
+	var rules []aclRule
+	n := 0
+	for line := range strings.Lines(content) {
+		n++
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) < 3 || (f[0] != "allow" && f[0] != "deny") || !strings.HasPrefix(f[1], "/") {
+			return nil, fmt.Errorf("line %d: want allow|deny /path/glob who...", n)
+		}
+		rule := aclRule{allow: f[0] == "allow", pattern: pathSegments(strings.ToLower(f[1])), who: f[2:], line: n, text: strings.Join(f, " ")}
+		for _, p := range append(slices.Clone(rule.pattern), rule.who...) {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("line %d: bad pattern %q", n, p)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// current returns the rules, re-reading the file if it has changed. If the
+// new rules don't parse, the old ones stay in force.
+func (a *accessList) current() []aclRule {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	info, err := os.Stat(a.file)
+	if err != nil {
+		a.rules, a.size, a.mod = nil, 0, time.Time{}
+		return nil
+	}
+	if info.Size() == a.size && info.ModTime().Equal(a.mod) {
+		return a.rules
+	}
+	a.size, a.mod = info.Size(), info.ModTime()
+	content, err := os.ReadFile(a.file)
+	if err == nil {
+		var rules []aclRule
+		if rules, err = parseACL(string(content)); err == nil {
+			a.rules = rules
+		}
+	}
+	if err != nil {
+		log.Printf("acl error, keeping previous rules: %v", err)
+	}
+	return a.rules
+}
+
+// check reports whether who may request the URL path p, and if not, the
+// rule that denies it. A nil accessList allows everything.
+func (a *accessList) check(who *apitype.WhoIsResponse, p string) (bool, *aclRule) {
+	if a == nil {
+		return true, nil
+	}
+	name := pathSegments(strings.ToLower(p))
+	for _, rule := range a.current() {
+		if globMatch(rule.pattern, name) && rule.applies(who) {
+			return rule.allow, &rule
+		}
+	}
+	return true, nil
+}
+
+// restricts reports whether some rule might deny who part of the tree
+// under the directory dir. Exports, which gather up a whole tree, aren't
+// offered to such requesters.
+func (a *accessList) restricts(who *apitype.WhoIsResponse, dir string) bool {
+	if a == nil {
+		return false
+	}
+	name := pathSegments(strings.ToLower(dir))
+	for _, rule := range a.current() {
+		switch {
+		case !rule.applies(who):
+		case rule.allow && globCovers(rule.pattern, name):
+			return false // nothing later can deny anything under dir
+		case !rule.allow && globOverlaps(rule.pattern, name):
+			return true
+		}
+	}
+	return false
+}
+
+// applies reports whether the rule names who.
+func (r *aclRule) applies(who *apitype.WhoIsResponse) bool {
+	for _, w := range r.who {
+		switch {
+		case w == "*":
+			return true
+		case who == nil || who.Node == nil:
+		case strings.HasPrefix(w, "tag:"):
+			for _, tag := range who.Node.Tags {
+				if ok, _ := path.Match(w, tag); ok {
+					return true
+				}
+			}
+		case strings.HasPrefix(w, "node:"):
+			name := strings.TrimSuffix(who.Node.ComputedName, ".")
+			short, _ := path.Match(strings.TrimPrefix(w, "node:"), firstLabel(name))
+			full, _ := path.Match(strings.TrimPrefix(w, "node:"), name)
+			if short || full {
+				return true
+			}
+		case who.UserProfile != nil && !who.Node.IsTagged():
+			if ok, _ := path.Match(strings.ToLower(w), strings.ToLower(who.UserProfile.LoginName)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pathSegments splits a slash-separated path into its cleaned segments,
+// the first of which is the empty root.
+func pathSegments(p string) []string {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return []string{""}
+	}
+	return strings.Split(p, "/")
+}
+
+// globMatch reports whether the path segments name match the glob pattern,
+// in which a ** segment matches any number of segments.
+func globMatch(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := range len(name) + 1 {
+				if globMatch(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// globOverlaps reports whether pattern could match dir or anything under
+// it.
+func globOverlaps(pattern, dir []string) bool {
+	for i, seg := range pattern {
+		if seg == "**" {
+			return true
+		}
+		if i == len(dir) {
+			return true // the pattern continues below dir
+		}
+		if ok, _ := path.Match(seg, dir[i]); !ok {
+			return false
+		}
+	}
+	return len(pattern) == len(dir)
+}
+
+// globCovers reports whether pattern matches everything under dir: it
+// ends in ** and the rest of it matches dir or one of its parents.
+func globCovers(pattern, dir []string) bool {
+	if len(pattern) == 0 || pattern[len(pattern)-1] != "**" {
+		return false
+	}
+	for k := range len(dir) + 1 {
+		if globMatch(pattern[:len(pattern)-1], dir[:k]) {
+			return true
+		}
+	}
+	return false
+}
+
+// identity returns the tailnet identity withIdentity attached to r.
+func identity(r *http.Request) *apitype.WhoIsResponse {
+	who, _ := r.Context().Value(identityKey{}).(*apitype.WhoIsResponse)
+	return who
+}
+
+// aclFor returns the rules that apply to r: none for requests on the
+// local listener.
+func aclFor(r *http.Request) *accessList {
+	if localRequest(r) {
+		return nil
+	}
+	return siteACL
+}
+
+// accessAllowed reports whether the requester of r may see the URL path p.
+func accessAllowed(r *http.Request, p string) bool {
+	ok, _ := aclFor(r).check(identity(r), p)
+	return ok
+}
+
+// requesterName describes who sent r for the access log: a tailnet user
+// and device, "public" and an address for Funnel requests, or "?".
+func requesterName(r *http.Request) string {
+	if src, ok := funnelSource(r); ok {
+		return fmt.Sprintf("public (%s)", src.Addr())
+	}
+	who := identity(r)
+	if who == nil {
+		return "?"
+	}
+	return fmt.Sprintf("%s (%s)", who.UserProfile.LoginName, firstLabel(who.Node.ComputedName))
+}
+
+// denyAccess logs a refused request, with why, and answers it with a 403.
+func denyAccess(w http.ResponseWriter, r *http.Request, why string) {
+	who := identity(r)
+	log.Printf("denied: %s %s by %s", requesterName(r), r.URL.Path, why)
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(struct {
+			Error string `json:"error"`
+			Path  string `json:"path"`
+		}{"forbidden", r.URL.Path})
+		return
+	}
+	var login string
+	if who != nil && who.UserProfile != nil {
+		login = who.UserProfile.LoginName
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	forbiddenTemplate.Execute(w, struct {
+		BaseCSS   template.CSS
+		CustomCSS template.CSS
+		Path      string
+		Login     string
+	}{
+		BaseCSS:   template.CSS(markdownCSS),
+		CustomCSS: template.CSS(customCSS),
+		Path:      r.URL.Path,
+		Login:     login,
+	})
+}
+
+// allowedEntries drops the entries of a JSON listing, at any depth, that
+// the requester of r may not see.
+func allowedEntries(r *http.Request, entries []listJSONEntry) []listJSONEntry {
+	if aclFor(r) == nil {
+		return entries
+	}
+	entries = slices.DeleteFunc(entries, func(e listJSONEntry) bool {
+		return !accessAllowed(r, e.Path)
+	})
+	for i := range entries {
+		if entries[i].Entries != nil {
+			entries[i].Entries = allowedEntries(r, entries[i].Entries)
+		}
+	}
+	return entries
+}
+
+var forbiddenTemplate = template.Must(template.New("forbidden").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Forbidden</title>
+<style>
+{{.BaseCSS}}
+.markdown-body {
+	box-sizing: border-box;
+	min-width: 200px;
+	max-width: 980px;
+	margin: 0 auto;
+	padding: 45px;
+}
+@media (max-width: 767px) {
+	.markdown-body { padding: 15px; }
+}
+{{.CustomCSS}}
+</style>
+</head>
+<body class="markdown-body">
+<h1>Forbidden</h1>
+<p>You don't have access to <code>{{.Path}}</code>{{with .Login}} as {{.}}{{end}}.</p>
+<p><a href="/">Back to the top</a></p>
+</body>
+</html>
+`))
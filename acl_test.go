@@ -0,0 +1,207 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+const testACL = `# who sees what
+allow /secrets/**   alice@example.com
+deny  /secrets/**   *
+deny  /drafts/*.md  *@contractor.com node:kiosk
+allow /ci/**        tag:ci
+deny  /ci/**        *
+`
+
+func whoIsUser(login, node string) *apitype.WhoIsResponse {
+	return &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{ComputedName: node + ".tail1234.ts.net."},
+		UserProfile: &tailcfg.UserProfile{LoginName: login},
+	}
+}
+
+func useACL(t *testing.T, content string) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "acl")
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := loadACL(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := siteACL
+	siteACL = a
+	t.Cleanup(func() { siteACL = old })
+}
+
+func TestACLCheck(t *testing.T) {
+	useACL(t, testACL)
+	alice := whoIsUser("alice@example.com", "laptop")
+	bob := whoIsUser("bob@example.com", "desktop")
+	carol := whoIsUser("carol@contractor.com", "mac")
+	kiosk := whoIsUser("bob@example.com", "kiosk")
+	ci := &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{ComputedName: "runner", Tags: []string{"tag:ci"}},
+		UserProfile: &tailcfg.UserProfile{LoginName: "tagged-devices"},
+	}
+
+	for _, tc := range []struct {
+		who  *apitype.WhoIsResponse
+		path string
+		want bool
+	}{
+		{alice, "/secrets/keys.txt", true},
+		{alice, "/Secrets/deep/er/keys.txt", true},
+		{bob, "/secrets/keys.txt", false},
+		{bob, "/SECRETS/keys.txt", false},
+		{bob, "/secrets", false},
+		{bob, "/docs/../secrets/keys.txt", false},
+		{nil, "/secrets/keys.txt", false},
+		{bob, "/secretsanta.md", true},
+		{carol, "/drafts/plan.md", false},
+		{carol, "/drafts/img/plan.png", true},
+		{kiosk, "/drafts/plan.md", false},
+		{bob, "/drafts/plan.md", true},
+		{ci, "/ci/build.log", true},
+		{bob, "/ci/build.log", false},
+		{nil, "/README.md", true},
+	} {
+		ok, rule := siteACL.check(tc.who, tc.path)
+		if ok != tc.want {
+			t.Errorf("check(%s, %s) = %v by %v, want %v", requesterName(withIdentity(httptest.NewRequest("GET", tc.path, nil), tc.who)), tc.path, ok, rule, tc.want)
+		}
+	}
+
+	if !siteACL.restricts(bob, "/") || !siteACL.restricts(bob, "/secrets/old/") {
+		t.Error("restricts: want / and /secrets/old/ restricted for bob")
+	}
+	if siteACL.restricts(bob, "/docs/") || siteACL.restricts(alice, "/secrets/") {
+		t.Error("restricts: /docs/ for bob or /secrets/ for alice restricted")
+	}
+	var none *accessList
+	if ok, _ := none.check(bob, "/secrets/keys.txt"); !ok {
+		t.Error("nil accessList denied a request")
+	}
+}
+
+func TestParseACL(t *testing.T) {
+	for _, content := range []string{
+		"allow /docs/**\n",
+		"permit /docs/** *\n",
+		"deny docs/** *\n",
+		"deny /docs/[ *\n",
+	} {
+		if _, err := parseACL(content); err == nil {
+			t.Errorf("parseACL(%q) succeeded", content)
+		}
+	}
+}
+
+func TestACLReload(t *testing.T) {
+	useACL(t, "deny /a.md *\n")
+	if ok, _ := siteACL.check(nil, "/a.md"); ok {
+		t.Fatal("/a.md allowed")
+	}
+	os.WriteFile(siteACL.file, []byte("deny /b.md *\n"), 0600)
+	os.Chtimes(siteACL.file, time.Now(), time.Now().Add(time.Minute))
+	if ok, _ := siteACL.check(nil, "/a.md"); !ok {
+		t.Error("/a.md still denied after the rule was removed")
+	}
+	// A bad edit keeps the rules in force.
+	os.WriteFile(siteACL.file, []byte("deny\n"), 0600)
+	os.Chtimes(siteACL.file, time.Now(), time.Now().Add(2*time.Minute))
+	if ok, _ := siteACL.check(nil, "/b.md"); ok {
+		t.Error("/b.md allowed after a bad edit")
+	}
+}
+
+func TestACLServe(t *testing.T) {
+	useACL(t, testACL)
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "README.md", "# Home\n\nSee [the keys](secrets/keys.md).\n", time.Time{})
+	writeFile(t, dir, "secrets/keys.md", "# Keys\n\nThe launch codes. Back to [home](../README.md).\n", time.Time{})
+	bob := whoIsUser("bob@example.com", "desktop")
+
+	get := func(target string, who *apitype.WhoIsResponse) *http.Request {
+		return withIdentity(httptest.NewRequest("GET", target, nil), who)
+	}
+
+	rec := httptest.NewRecorder()
+	denyAccess(rec, get("/secrets/keys.md", bob), "rule 2")
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "You don't have access to <code>/secrets/keys.md</code> as bob@example.com") {
+		t.Errorf("403 page: %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Listings, search results and backlinks leave out what bob can't see.
+	rec = httptest.NewRecorder()
+	serveDirList(rec, get("/?list", bob), "/")
+	if strings.Contains(rec.Body.String(), "secrets") {
+		t.Errorf("listing shows secrets/: %s", rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	req := get("/?format=json&recursive", bob)
+	serveDirList(rec, req, "/")
+	if strings.Contains(rec.Body.String(), "secrets") {
+		t.Errorf("JSON listing shows secrets/: %s", rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	serveSearch(rec, get("/?search=launch&format=json", bob), "/")
+	if strings.Contains(rec.Body.String(), "keys.md") {
+		t.Errorf("search finds secrets/keys.md: %s", rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	serveMarkdown(rec, get("/README.md", bob), "/README.md")
+	if strings.Contains(rec.Body.String(), "Linked from") {
+		t.Errorf("backlinks show secrets/keys.md: %s", rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	serveExport(rec, get("/?export", bob), "/")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("export of / for bob: code %d, want 403", rec.Code)
+	}
+
+	// Alice sees everything.
+	alice := whoIsUser("alice@example.com", "laptop")
+	rec = httptest.NewRecorder()
+	serveSearch(rec, get("/?search=launch&format=json", alice), "/")
+	if !strings.Contains(rec.Body.String(), "keys.md") {
+		t.Errorf("search for alice misses secrets/keys.md: %s", rec.Body.String())
+	}
+
+	// [[Links]] don't resolve to pages the requester can't see.
+	writeFile(t, dir, "wiki.md", "# Wiki\n\nSee [[Keys]].\n", time.Time{})
+	for who, want := range map[*apitype.WhoIsResponse]bool{bob: false, alice: true} {
+		rec = httptest.NewRecorder()
+		serveMarkdown(rec, get("/wiki.md", who), "/wiki.md")
+		if got := strings.Contains(rec.Body.String(), `href="secrets/keys.md"`); got != want {
+			t.Errorf("[[Keys]] for %s resolved: %v, want %v: %s", who.UserProfile.LoginName, got, want, rec.Body.String())
+		}
+	}
+
+	// A directory whose index is denied gets a listing without it.
+	writeFile(t, dir, "drafts/README.md", "# Draft plan\n", time.Time{})
+	writeFile(t, dir, "drafts/notes.txt", "notes\n", time.Time{})
+	carol := whoIsUser("carol@contractor.com", "mac")
+	if got := dirIndex(get("/drafts/", bob), "/drafts/"); got != "/drafts/README.md" {
+		t.Errorf("index of /drafts/ for bob = %q", got)
+	}
+	req = get("/drafts/", carol)
+	if got := dirIndex(req, "/drafts/"); got != "/drafts/" {
+		t.Fatalf("index of /drafts/ for carol = %q", got)
+	}
+	rec = httptest.NewRecorder()
+	serveDirList(rec, req, "/drafts/")
+	if body := rec.Body.String(); strings.Contains(body, "Draft plan") || strings.Contains(body, "README.md") || !strings.Contains(body, "notes.txt") {
+		t.Errorf("listing of /drafts/ for carol: %s", body)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// githubAlerts renders GitHub's alert syntax, a blockquote whose first line
+// is one of the markers below:
+//
+//	> [!NOTE]
+//	> Useful information.
+//
+// The markup matches github.com, so markdown.css already styles it.
+type githubAlerts struct{}
+
+func (githubAlerts) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(githubAlertTransformer{}, 100),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(alertRenderer{}, 100),
+	))
+}
+
+// alertIcons holds the octicon path GitHub shows for each alert type.
+var alertIcons = map[string]string{
+	"note":      `M0 8a8 8 0 1 1 16 0A8 8 0 0 1 0 8Zm8-6.5a6.5 6.5 0 1 0 0 13 6.5 6.5 0 0 0 0-13ZM6.5 7.75A.75.75 0 0 1 7.25 7h1a.75.75 0 0 1 .75.75v2.75h.25a.75.75 0 0 1 0 1.5h-2a.75.75 0 0 1 0-1.5h.25v-2h-.25a.75.75 0 0 1-.75-.75ZM8 6a1 1 0 1 1 0-2 1 1 0 0 1 0 2Z`,
+	"tip":       `M8 1.5c-2.363 0-4 1.69-4 3.75 0 .984.424 1.625.984 2.304l.214.253c.223.264.47.556.673.848.284.411.537.896.621 1.49a.75.75 0 0 1-1.484.211c-.04-.282-.163-.547-.37-.847a8.456 8.456 0 0 0-.542-.68c-.084-.1-.173-.205-.268-.32C3.201 7.75 2.5 6.766 2.5 5.25 2.5 2.31 4.863 0 8 0s5.5 2.31 5.5 5.25c0 1.516-.701 2.5-1.328 3.259-.095.115-.184.22-.268.319-.207.245-.383.453-.541.681-.208.3-.33.565-.37.847a.751.751 0 0 1-1.485-.212c.084-.593.337-1.078.621-1.489.203-.292.45-.584.673-.848.075-.088.147-.173.213-.253.561-.679.985-1.32.985-2.304 0-2.06-1.637-3.75-4-3.75ZM5.75 12h4.5a.75.75 0 0 1 0 1.5h-4.5a.75.75 0 0 1 0-1.5ZM6 15.25a.75.75 0 0 1 .75-.75h2.5a.75.75 0 0 1 0 1.5h-2.5a.75.75 0 0 1-.75-.75Z`,
+	"important": `M0 1.75C0 .784.784 0 1.75 0h12.5C15.216 0 16 .784 16 1.75v9.5A1.75 1.75 0 0 1 14.25 13H8.06l-2.573 2.573A1.458 1.458 0 0 1 3 14.543V13H1.75A1.75 1.75 0 0 1 0 11.25Zm1.75-.25a.25.25 0 0 0-.25.25v9.5c0 .138.112.25.25.25h2a.75.75 0 0 1 .75.75v2.19l2.72-2.72a.749.749 0 0 1 .53-.22h6.5a.25.25 0 0 0 .25-.25v-9.5a.25.25 0 0 0-.25-.25Zm7 2.25v2.5a.75.75 0 0 1-1.5 0v-2.5a.75.75 0 0 1 1.5 0ZM9 9a1 1 0 1 1-2 0 1 1 0 0 1 2 0Z`,
+	"warning":   `M6.457 1.047c.659-1.234 2.427-1.234 3.086 0l6.082 11.378A1.75 1.75 0 0 1 14.082 15H1.918a1.75 1.75 0 0 1-1.543-2.575Zm1.763.707a.25.25 0 0 0-.44 0L1.698 13.132a.25.25 0 0 0 .22.368h12.164a.25.25 0 0 0 .22-.368Zm.53 3.996v2.5a.75.75 0 0 1-1.5 0v-2.5a.75.75 0 0 1 1.5 0ZM9 11a1 1 0 1 1-2 0 1 1 0 0 1 2 0Z`,
+	"caution":   `M4.47.22A.749.749 0 0 1 5 0h6c.199 0 .389.079.53.22l4.25 4.25c.141.14.22.331.22.53v6a.749.749 0 0 1-.22.53l-4.25 4.25A.749.749 0 0 1 11 16H5a.749.749 0 0 1-.53-.22L.22 11.53A.749.749 0 0 1 0 11V5c0-.199.079-.389.22-.53Zm.84 1.28L1.5 5.31v5.38l3.81 3.81h5.38l3.81-3.81V5.31L10.69 1.5ZM8 4a.75.75 0 0 1 .75.75v3.5a.75.75 0 0 1-1.5 0v-3.5A.75.75 0 0 1 8 4Zm0 8a1 1 0 1 1 0-2 1 1 0 0 1 0 2Z`,
+}
+
+var kindAlert = ast.NewNodeKind("Alert")
+
+type alertBlock struct {
+	ast.BaseBlock
+	Alert string // key of alertIcons
+}
+
+func (n *alertBlock) Kind() ast.NodeKind { return kindAlert }
+
+func (n *alertBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Alert": n.Alert}, nil)
+}
+
+// githubAlertTransformer replaces blockquotes that start with an alert
+// marker with alert blocks holding the rest of the quote.
+type githubAlertTransformer struct{}
+
+func (githubAlertTransformer) Transform(doc *ast.Document, reader text.Reader, _ parser.Context) {
+	source := reader.Source()
+	var quotes []*ast.Blockquote
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if q, ok := n.(*ast.Blockquote); ok && entering {
+			quotes = append(quotes, q)
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, q := range quotes {
+		p, ok := q.FirstChild().(*ast.Paragraph)
+		if !ok || p.Lines().Len() == 0 {
+			continue
+		}
+		marker := p.Lines().At(0)
+		typ, ok := alertType(marker.Value(source))
+		if !ok {
+			continue
+		}
+		// Drop the inline nodes parsed from the marker line, and the
+		// paragraph itself if the marker was all it held.
+		for c := p.FirstChild(); c != nil; {
+			t, ok := c.(*ast.Text)
+			if !ok || t.Segment.Start >= marker.Stop {
+				break
+			}
+			next := c.NextSibling()
+			p.RemoveChild(p, c)
+			c = next
+		}
+		if p.ChildCount() == 0 {
+			q.RemoveChild(q, p)
+		}
+		alert := &alertBlock{Alert: typ}
+		for c := q.FirstChild(); c != nil; {
+			next := c.NextSibling()
+			alert.AppendChild(alert, c)
+			c = next
+		}
+		q.Parent().ReplaceChild(q.Parent(), q, alert)
+	}
+}
+
+// alertType reports which alert, if any, line marks. Like GitHub, the
+// marker is case-insensitive and must be alone on its line.
+func alertType(line []byte) (string, bool) {
+	s := string(bytes.TrimSpace(line))
+	if !strings.HasPrefix(s, "[!") || !strings.HasSuffix(s, "]") {
+		return "", false
+	}
+	typ := strings.ToLower(s[2 : len(s)-1])
+	_, ok := alertIcons[typ]
+	return typ, ok
+}
+
+type alertRenderer struct{}
+
+func (alertRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindAlert, func(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			w.WriteString("</div>\n")
+			return ast.WalkContinue, nil
+		}
+		typ := n.(*alertBlock).Alert
+		w.WriteString(`<div class="markdown-alert markdown-alert-` + typ + `">` + "\n")
+		w.WriteString(`<p class="markdown-alert-title">`)
+		w.WriteString(`<svg class="octicon mr-2" viewBox="0 0 16 16" width="16" height="16" aria-hidden="true"><path d="` + alertIcons[typ] + `"></path></svg>`)
+		w.WriteString(strings.ToUpper(typ[:1]) + typ[1:])
+		w.WriteString("</p>\n")
+		return ast.WalkContinue, nil
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdownAlerts(t *testing.T) {
+	for _, typ := range []string{"NOTE", "Tip", "important", "WARNING", "CAUTION"} {
+		src := "> [!" + typ + "]\n> Be *careful*.\n"
+		page, err := renderMarkdown([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(page.Content)
+		lower := strings.ToLower(typ)
+		title := strings.ToUpper(lower[:1]) + lower[1:]
+		if !strings.Contains(got, `<div class="markdown-alert markdown-alert-`+lower+`">`) {
+			t.Errorf("%s: no alert block: %s", typ, got)
+		}
+		if !strings.Contains(got, `</svg>`+title+`</p>`) {
+			t.Errorf("%s: missing title: %s", typ, got)
+		}
+		if !strings.Contains(got, "<p>Be <em>careful</em>.</p>") {
+			t.Errorf("%s: body not rendered: %s", typ, got)
+		}
+		if strings.Contains(got, "[!") || strings.Contains(got, "<blockquote>") {
+			t.Errorf("%s: marker or blockquote left behind: %s", typ, got)
+		}
+	}
+}
+
+func TestRenderMarkdownAlertSeparateParagraph(t *testing.T) {
+	page, err := renderMarkdown([]byte("> [!NOTE]\n>\n> First.\n>\n> Second.\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(page.Content)
+	if strings.Contains(got, "<p></p>") || !strings.Contains(got, "<p>First.</p>\n<p>Second.</p>") {
+		t.Errorf("unexpected alert body: %s", got)
+	}
+}
+
+func TestRenderMarkdownNotAlerts(t *testing.T) {
+	for _, src := range []string{
+		"> [!NOTE] with text on the marker line\n",
+		"> [!UNKNOWN]\n> text\n",
+		"> Just a quote.\n",
+	} {
+		page, err := renderMarkdown([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(page.Content)
+		if !strings.Contains(got, "<blockquote>") || strings.Contains(got, "markdown-alert") {
+			t.Errorf("%q should stay a blockquote: %s", src, got)
+		}
+	}
+}
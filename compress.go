@@ -0,0 +1,268 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// minCompressSize is the smallest response worth compressing, when its
+// length is known up front.
+const minCompressSize = 1024
+
+// encoder is a compressing writer that can be reused for another response.
+type encoder interface {
+	io.Writer
+	Flush() error
+	Close() error
+	Reset(io.Writer)
+}
+
+// encodings are the content codings serve can produce, in order of
+// preference.
+var encodings = []struct {
+	name string
+	pool sync.Pool
+}{
+	{name: "zstd", pool: sync.Pool{New: func() any {
+		// Browsers won't decode zstd windows over 8 MiB.
+		e, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(4<<20))
+		return e
+	}}},
+	{name: "br", pool: sync.Pool{New: func() any { return brotli.NewWriterLevel(nil, 5) }}},
+	{name: "gzip", pool: sync.Pool{New: func() any { return gzip.NewWriter(nil) }}},
+}
+
+// compress wraps h so that text responses are compressed with the best
+// coding the client accepts. Responses that are already encoded, partial,
+// small, or of types that don't shrink (images, archives, video) are sent
+// as they are.
+func compress(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &compressWriter{ResponseWriter: w, accept: r.Header.Get("Accept-Encoding")}
+		defer cw.close()
+		h.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding returns the index in encodings of the most preferred
+// coding that the Accept-Encoding header accept allows, or -1 for none.
+func acceptedEncoding(accept string) int {
+	q := make(map[string]float64)
+	for part := range strings.SplitSeq(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		q[name] = weight
+	}
+	best, bestWeight := -1, 0.0
+	for i := range encodings {
+		weight, ok := q[encodings[i].name]
+		if !ok {
+			weight = q["*"]
+		}
+		if weight > bestWeight {
+			best, bestWeight = i, weight
+		}
+	}
+	return best
+}
+
+// compressible reports whether responses of the given Content-Type are
+// worth compressing.
+func compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mt == "text/event-stream":
+		return false // each event is flushed on its own; nothing to gain
+	case strings.HasPrefix(mt, "text/"),
+		strings.HasSuffix(mt, "+json"),
+		strings.HasSuffix(mt, "+xml"):
+		return true
+	}
+	switch mt {
+	case "application/json", "application/javascript", "application/xml",
+		"application/wasm", "application/x-ndjson", "application/toml",
+		"application/yaml", "application/x-yaml", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// compressWriter compresses a response if, once its headers are known, it
+// turns out to be worth it.
+type compressWriter struct {
+	http.ResponseWriter
+	accept      string // the request's Accept-Encoding
+	wroteHeader bool
+	coding      int // index in encodings, if enc is set
+	enc         encoder
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	if code >= 100 && code < 200 {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.wroteHeader = true
+	cw.start(code)
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+// start decides whether to compress a response with status code, and if
+// so, adjusts its headers and sets up the encoder.
+func (cw *compressWriter) start(code int) {
+	h := cw.Header()
+	if !compressible(h.Get("Content-Type")) {
+		return
+	}
+	h.Add("Vary", "Accept-Encoding")
+	if code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent ||
+		h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < minCompressSize {
+		return
+	}
+	i := acceptedEncoding(cw.accept)
+	if i < 0 {
+		return
+	}
+	h.Set("Content-Encoding", encodings[i].name)
+	h.Del("Content-Length")
+	h.Del("Accept-Ranges")
+	// The encoded body differs byte for byte from the identity one.
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		h.Set("ETag", "W/"+etag)
+	}
+	cw.coding = i
+	cw.enc = encodings[i].pool.Get().(encoder)
+	cw.enc.Reset(cw.ResponseWriter)
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush sends what has been compressed so far, so streamed responses
+// arrive as they're written.
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close finishes the compressed stream and returns the encoder to its pool.
+func (cw *compressWriter) close() {
+	if cw.enc == nil {
+		return
+	}
+	cw.enc.Close()
+	cw.enc.Reset(io.Discard)
+	encodings[cw.coding].pool.Put(cw.enc)
+	cw.enc = nil
+}
+
+// precompressed lists the sibling files servePrecompressed looks for, by
+// content coding.
+var precompressed = []struct{ coding, ext string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// servePrecompressed serves a static file from a compressed copy beside it,
+// such as app.js.br or app.js.gz, if -precompressed is set, the client
+// accepts that coding, and the copy is at least as new as the file.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, path string) bool {
+	if !*precompress || strings.HasSuffix(path, "/") {
+		return false
+	}
+	clean := filepath.Clean(strings.TrimPrefix(path, "/"))
+	if strings.HasPrefix(clean, "..") {
+		return false
+	}
+	info, err := os.Stat(clean)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	accept := strings.ToLower(r.Header.Get("Accept-Encoding"))
+	for _, pc := range precompressed {
+		if !acceptsCoding(accept, pc.coding) {
+			continue
+		}
+		if cinfo, err := os.Stat(clean + pc.ext); err != nil || cinfo.IsDir() || cinfo.ModTime().Before(info.ModTime()) {
+			continue
+		}
+		f, err := os.Open(clean + pc.ext)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		h := w.Header()
+		h.Set("Content-Type", getMimeType(clean))
+		h.Set("Content-Encoding", pc.coding)
+		h.Add("Vary", "Accept-Encoding")
+		http.ServeContent(w, r, clean, info.ModTime(), f)
+		return true
+	}
+	return false
+}
+
+// acceptsCoding reports whether the Accept-Encoding header accept allows
+// coding.
+func acceptsCoding(accept, coding string) bool {
+	for part := range strings.SplitSeq(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		if strings.TrimSpace(name) != coding {
+			continue
+		}
+		v, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		return err == nil && f > 0
+	}
+	return false
+}
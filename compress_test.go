@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                        "",
+		"gzip":                    "gzip",
+		"gzip, deflate, br, zstd": "zstd",
+		"gzip, br":                "br",
+		"br;q=0.5, gzip":          "gzip",
+		"zstd;q=0, gzip;q=0":      "",
+		"*":                       "zstd",
+		"identity":                "",
+	} {
+		got := ""
+		if i := acceptedEncoding(accept); i >= 0 {
+			got = encodings[i].name
+		}
+		if got != want {
+			t.Errorf("acceptedEncoding(%q) = %q, want %q", accept, got, want)
+		}
+	}
+}
+
+func decode(t *testing.T, coding string, body []byte) string {
+	t.Helper()
+	var r io.Reader = bytes.NewReader(body)
+	switch coding {
+	case "gzip":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	case "br":
+		r = brotli.NewReader(r)
+	case "zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("decoding %s: %v", coding, err)
+	}
+	return string(b)
+}
+
+func TestCompress(t *testing.T) {
+	page := strings.Repeat("<p>Some text that compresses well.</p>\n", 100)
+	h := compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("ETag", `"v1"`)
+			io.WriteString(w, page)
+		case "/small":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Length", "5")
+			io.WriteString(w, "small")
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, page)
+		}
+	}))
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, coding := range []string{"gzip", "br", "zstd"} {
+		rec := get("/page", coding)
+		if got := rec.Header().Get("Content-Encoding"); got != coding {
+			t.Errorf("%s: Content-Encoding = %q", coding, got)
+			continue
+		}
+		if rec.Body.Len() >= len(page) {
+			t.Errorf("%s: body not smaller: %d bytes", coding, rec.Body.Len())
+		}
+		if got := decode(t, coding, rec.Body.Bytes()); got != page {
+			t.Errorf("%s: decoded body differs", coding)
+		}
+		if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+			t.Errorf("%s: Content-Type = %q", coding, got)
+		}
+		if got := rec.Header().Get("ETag"); got != `W/"v1"` {
+			t.Errorf("%s: ETag = %q, want weakened", coding, got)
+		}
+		if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("%s: Vary = %q", coding, got)
+		}
+	}
+
+	for _, tc := range []struct{ path, accept string }{
+		{"/page", ""},
+		{"/small", "gzip"},
+		{"/image", "gzip"},
+	} {
+		if rec := get(tc.path, tc.accept); rec.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s with %q compressed", tc.path, tc.accept)
+		}
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	h := compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "first")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, "second")
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q", res.Header.Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil || string(b) != "firstsecond" {
+		t.Errorf("body = %q, %v", b, err)
+	}
+}
+
+func TestServePrecompressed(t *testing.T) {
+	old := *precompress
+	*precompress = true
+	t.Cleanup(func() { *precompress = old })
+	dir := t.TempDir()
+	t.Chdir(dir)
+	mod := time.Now().Add(-time.Hour)
+	writeFile(t, dir, "app.js", "console.log('hi')\n", mod)
+	writeFile(t, dir, "app.js.gz", "gzipped", mod)
+	writeFile(t, dir, "old.css", "body {}\n", mod)
+	writeFile(t, dir, "old.css.br", "stale", mod.Add(-time.Minute))
+
+	serve := func(path, accept string) (*httptest.ResponseRecorder, bool) {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", accept)
+		rec := httptest.NewRecorder()
+		return rec, servePrecompressed(rec, req, path)
+	}
+	rec, ok := serve("/app.js", "gzip, br")
+	if !ok || rec.Body.String() != "gzipped" || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("app.js: served %v, %q, Content-Encoding %q", ok, rec.Body.String(), rec.Header().Get("Content-Encoding"))
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.Contains(got, "javascript") {
+		t.Errorf("app.js: Content-Type = %q", got)
+	}
+	if _, ok := serve("/app.js", "br"); ok {
+		t.Error("served gzip copy to a client without gzip")
+	}
+	if _, ok := serve("/old.css", "br"); ok {
+		t.Error("served a copy older than the file")
+	}
+}
@@ -0,0 +1,364 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listEntry is one row of a directory listing.
+type listEntry struct {
+	Name    string // with a trailing slash for directories
+	Href    string
+	IsDir   bool
+	Size    int64 // zero for directories
+	ModTime time.Time
+	Type    string // key of fileTypeIcons
+	Link    bool   // a symlink
+}
+
+// newListEntry describes the entry name in dir, following symlinks so that
+// a link to a directory lists as one.
+func newListEntry(dir string, e os.DirEntry) listEntry {
+	info, err := os.Stat(filepath.Join(dir, e.Name()))
+	if err != nil {
+		info, err = e.Info() // a dangling symlink
+	}
+	le := listEntry{Name: e.Name(), Type: "file", Link: e.Type()&os.ModeSymlink != 0}
+	if err == nil {
+		le.ModTime = info.ModTime()
+		le.IsDir = info.IsDir()
+		if !le.IsDir {
+			le.Size = info.Size()
+		}
+	}
+	if le.IsDir {
+		le.Name += "/"
+		le.Type = "dir"
+	} else {
+		le.Type = fileType(le.Name)
+	}
+	le.Href = (&url.URL{Path: le.Name}).String()
+	return le
+}
+
+// fileTypeIcons maps the coarse file types shown in listings to icons.
+var fileTypeIcons = map[string]string{
+	"dir":      "📁",
+	"markdown": "📝",
+	"text":     "📃",
+	"code":     "💻",
+	"image":    "🖼️",
+	"video":    "🎞️",
+	"audio":    "🎵",
+	"pdf":      "📕",
+	"archive":  "📦",
+	"file":     "📄",
+}
+
+var codeExts = map[string]bool{
+	".c": true, ".cc": true, ".cpp": true, ".cs": true, ".css": true,
+	".go": true, ".h": true, ".html": true, ".java": true, ".js": true,
+	".json": true, ".kt": true, ".lua": true, ".php": true, ".py": true,
+	".rb": true, ".rs": true, ".sh": true, ".sql": true, ".swift": true,
+	".toml": true, ".ts": true, ".tsx": true, ".xml": true, ".yaml": true,
+	".yml": true, ".zig": true,
+}
+
+var archiveExts = map[string]bool{
+	".7z": true, ".bz2": true, ".dmg": true, ".gz": true, ".iso": true,
+	".jar": true, ".rar": true, ".tar": true, ".tgz": true, ".xz": true,
+	".zip": true, ".zst": true,
+}
+
+// fileType classifies a file by its name.
+func fileType(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	switch {
+	case ext == ".md" || ext == ".markdown":
+		return "markdown"
+	case ext == ".pdf":
+		return "pdf"
+	case codeExts[ext]:
+		return "code"
+	case archiveExts[ext]:
+		return "archive"
+	case searchExts[ext]:
+		return "text"
+	}
+	mimeType := getMimeType(name)
+	for _, t := range []string{"image", "video", "audio", "text"} {
+		if strings.HasPrefix(mimeType, t+"/") {
+			return t
+		}
+	}
+	return "file"
+}
+
+func (e listEntry) Icon() string { return fileTypeIcons[e.Type] }
+
+// SizeText is the entry's size for people, or "—" for directories.
+func (e listEntry) SizeText() string {
+	if e.IsDir {
+		return "—"
+	}
+	return formatSize(e.Size)
+}
+
+func (e listEntry) Modified() string {
+	if e.ModTime.IsZero() {
+		return ""
+	}
+	return e.ModTime.Format("2006-01-02 15:04")
+}
+
+// formatSize formats n bytes using binary units, e.g. "512 B" or "1.5 MB".
+func formatSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	v := float64(n) / 1024
+	unit := 0
+	units := []string{"KB", "MB", "GB", "TB", "PB"}
+	for v >= 1024 && unit < len(units)-1 {
+		v /= 1024
+		unit++
+	}
+	if v < 10 {
+		return fmt.Sprintf("%.1f %s", v, units[unit])
+	}
+	return fmt.Sprintf("%.0f %s", v, units[unit])
+}
+
+// listSort is how a listing is ordered, from ?sort= and ?order=.
+type listSort struct {
+	By   string // "name", "size" or "mtime"
+	Desc bool
+}
+
+func listSortFrom(r *http.Request) listSort {
+	q := r.URL.Query()
+	s := listSort{By: q.Get("sort"), Desc: q.Get("order") == "desc"}
+	if s.By != "size" && s.By != "mtime" {
+		s.By = "name"
+	}
+	return s
+}
+
+// sortEntries orders list by s, keeping directories ahead of files and
+// breaking ties by name.
+func sortEntries(list []listEntry, s listSort) {
+	slices.SortStableFunc(list, func(a, b listEntry) int {
+		if a.IsDir != b.IsDir {
+			if a.IsDir {
+				return -1
+			}
+			return 1
+		}
+		var c int
+		switch s.By {
+		case "size":
+			c = cmp.Compare(a.Size, b.Size)
+		case "mtime":
+			c = a.ModTime.Compare(b.ModTime)
+		}
+		if c == 0 {
+			c = cmp.Or(
+				cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)),
+				cmp.Compare(a.Name, b.Name),
+			)
+		}
+		if s.Desc {
+			c = -c
+		}
+		return c
+	})
+}
+
+// listColumn is a sortable column heading of a listing.
+type listColumn struct {
+	Label string
+	Class string
+	Href  string // sorts by this column, or reverses it if it's current
+	Arrow string // shows the current sort direction
+}
+
+// listColumns links each column heading to the listing sorted by it,
+// keeping the rest of the query (such as ?list). Size and modification time
+// sort descending first, so the largest and newest entries come first.
+func listColumns(r *http.Request, s listSort) []listColumn {
+	cols := []listColumn{
+		{Label: "Name", Class: "name"},
+		{Label: "Size", Class: "size"},
+		{Label: "Modified", Class: "mtime"},
+	}
+	for i, by := range []string{"name", "size", "mtime"} {
+		desc := by != "name"
+		if by == s.By {
+			desc = !s.Desc
+			cols[i].Arrow = " ↑"
+			if s.Desc {
+				cols[i].Arrow = " ↓"
+			}
+		}
+		q := r.URL.Query()
+		q.Del("livereload")
+		q.Set("sort", by)
+		if desc {
+			q.Set("order", "desc")
+		} else {
+			q.Set("order", "asc")
+		}
+		cols[i].Href = "?" + q.Encode()
+	}
+	return cols
+}
+
+// crumb is one link in a listing's breadcrumb trail.
+type crumb struct {
+	Name string
+	Href string // empty for the current directory
+}
+
+// breadcrumbs splits a directory's URL path into links to each parent,
+// starting at the served root, which is named after the working directory.
+func breadcrumbs(urlPath string) []crumb {
+	root := "/"
+	if wd, err := os.Getwd(); err == nil {
+		root = filepath.Base(wd)
+	}
+	crumbs := []crumb{{Name: root, Href: "/"}}
+	href := "/"
+	for name := range strings.SplitSeq(strings.Trim(urlPath, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		href += name + "/"
+		crumbs = append(crumbs, crumb{Name: name, Href: (&url.URL{Path: href}).String()})
+	}
+	crumbs[len(crumbs)-1].Href = ""
+	return crumbs
+}
+
+// maxListDepth caps how many levels a recursive JSON listing descends.
+const maxListDepth = 16
+
+// listJSONEntry is a listing entry as served to scripts.
+type listJSONEntry struct {
+	Name    string          `json:"name"`
+	Path    string          `json:"path"` // URL path, unescaped
+	Type    string          `json:"type"` // "dir", or a coarse file type such as "markdown" or "image"
+	Size    int64           `json:"size"`
+	ModTime time.Time       `json:"mtime"`
+	URLs    listURLs        `json:"urls"`
+	Entries []listJSONEntry `json:"entries,omitempty"` // a directory's contents, in recursive listings
+}
+
+// listURLs are the ways an entry can be fetched. Raw is the file as stored,
+// Rendered the HTML page serve shows for it, and Download a file to save:
+// a zip of the rendered page or directory, or the file itself.
+type listURLs struct {
+	Raw      string `json:"raw,omitempty"`
+	Rendered string `json:"rendered,omitempty"`
+	Download string `json:"download"`
+}
+
+func entryURLs(urlPath string, e listEntry) listURLs {
+	u := (&url.URL{Path: urlPath}).EscapedPath()
+	switch {
+	case e.IsDir:
+		return listURLs{Rendered: u, Download: u + "?export"}
+	case e.Type == "markdown":
+		return listURLs{Raw: u + "?raw", Rendered: u, Download: u + "?download"}
+	}
+	return listURLs{Raw: u, Download: u}
+}
+
+// listDirJSON lists the directory dir, served at urlPath, descending depth
+// levels. Subdirectories that can't be read, symlinks to directories (which
+// may lead back up the tree), and serve's state and version control
+// directories are listed without their contents.
+func listDirJSON(dir, urlPath string, s listSort, depth int) ([]listJSONEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]listEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, newListEntry(dir, e))
+	}
+	sortEntries(list, s)
+
+	out := make([]listJSONEntry, 0, len(list))
+	for _, e := range list {
+		name := strings.TrimSuffix(e.Name, "/")
+		je := listJSONEntry{
+			Name:    name,
+			Path:    urlPath + e.Name,
+			Type:    e.Type,
+			Size:    e.Size,
+			ModTime: e.ModTime,
+			URLs:    entryURLs(urlPath+e.Name, e),
+		}
+		if e.IsDir && !e.Link && depth > 1 && !skippedDir(name) {
+			je.Entries, _ = listDirJSON(filepath.Join(dir, name), je.Path, s, depth-1)
+		}
+		out = append(out, je)
+	}
+	return out, nil
+}
+
+// serveDirListJSON writes the listing of dir as JSON. ?recursive lists
+// subdirectories too, up to maxListDepth levels, or ?depth=n levels.
+func serveDirListJSON(w http.ResponseWriter, r *http.Request, dir, urlPath string) {
+	q := r.URL.Query()
+	depth := 1
+	if q.Has("recursive") {
+		depth = maxListDepth
+	}
+	if n, err := strconv.Atoi(q.Get("depth")); err == nil && n > 0 {
+		depth = min(n, maxListDepth)
+	}
+	entries, err := listDirJSON(dir, urlPath, listSortFrom(r), depth)
+	if err != nil {
+		http.Error(w, "failed to list directory", http.StatusInternalServerError)
+		return
+	}
+	entries = allowedEntries(r, entries)
+	var buf bytes.Buffer
+	json.NewEncoder(&buf).Encode(struct {
+		Path    string          `json:"path"`
+		Entries []listJSONEntry `json:"entries"`
+	}{urlPath, entries})
+	// No Last-Modified: a recursive listing can change without dir's
+	// modification time doing so.
+	w.Header().Set("Content-Type", "application/json")
+	serveRendered(w, r, contentETag(buf.Bytes()), time.Time{}, buf.Bytes())
+}
+
+// listModTime returns the latest modification time of dir and the entries
+// listed in it, for a listing's Last-Modified.
+func listModTime(dir string, list []listEntry) time.Time {
+	var mod time.Time
+	if info, err := os.Stat(dir); err == nil {
+		mod = info.ModTime()
+	}
+	for _, e := range list {
+		if e.ModTime.After(mod) {
+			mod = e.ModTime
+		}
+	}
+	return mod
+}
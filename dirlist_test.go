@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatSize(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:                   "0 B",
+		1023:                "1023 B",
+		1536:                "1.5 KB",
+		20 * 1024:           "20 KB",
+		5 << 30:             "5.0 GB",
+		1 << 20 * 1024 * 10: "10 GB",
+	} {
+		if got := formatSize(n); got != want {
+			t.Errorf("formatSize(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestSortEntries(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	list := []listEntry{
+		{Name: "b.txt", Size: 10, ModTime: day.Add(2 * time.Hour)},
+		{Name: "Z/", IsDir: true, ModTime: day},
+		{Name: "a.txt", Size: 30, ModTime: day},
+		{Name: "c.txt", Size: 20, ModTime: day.Add(time.Hour)},
+		{Name: "a/", IsDir: true, ModTime: day.Add(time.Hour)},
+	}
+	for _, c := range []struct {
+		sort listSort
+		want string
+	}{
+		{listSort{By: "name"}, "a/ Z/ a.txt b.txt c.txt"},
+		{listSort{By: "name", Desc: true}, "Z/ a/ c.txt b.txt a.txt"},
+		{listSort{By: "size", Desc: true}, "Z/ a/ a.txt c.txt b.txt"},
+		{listSort{By: "mtime"}, "Z/ a/ a.txt c.txt b.txt"},
+	} {
+		sortEntries(list, c.sort)
+		var names []string
+		for _, e := range list {
+			names = append(names, e.Name)
+		}
+		if got := strings.Join(names, " "); got != c.want {
+			t.Errorf("%+v: got %s, want %s", c.sort, got, c.want)
+		}
+	}
+}
+
+func TestFileType(t *testing.T) {
+	for name, want := range map[string]string{
+		"README.md":   "markdown",
+		"main.go":     "code",
+		"photo.JPG":   "image",
+		"build.tgz":   "archive",
+		"notes.txt":   "text",
+		"manual.pdf":  "pdf",
+		"clip.mp4":    "video",
+		"binary.blob": "file",
+	} {
+		if got := fileType(name); got != want {
+			t.Errorf("fileType(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestBreadcrumbs(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	var got []string
+	for _, c := range breadcrumbs("/docs/my notes/") {
+		got = append(got, c.Name+"="+c.Href)
+	}
+	root := breadcrumbs("/")[0].Name
+	want := root + "=/ docs=/docs/ my notes="
+	if strings.Join(got, " ") != want {
+		t.Errorf("breadcrumbs = %q, want %q", strings.Join(got, " "), want)
+	}
+}
+
+func TestServeDirListSorting(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "README.md", "# Home\n", time.Time{})
+	writeFile(t, dir, "big.bin", strings.Repeat("x", 3000), time.Now().Add(-time.Hour))
+	writeFile(t, dir, "new.txt", "hi", time.Now())
+	writeFile(t, dir, "sub/x.md", "x", time.Time{})
+
+	rec := httptest.NewRecorder()
+	serveDirList(rec, httptest.NewRequest("GET", "/?list&sort=size&order=desc", nil), "/")
+	body := rec.Body.String()
+	order := []string{`href="sub/"`, `href="big.bin"`, `href="README.md"`, `href="new.txt"`}
+	last := -1
+	for _, s := range order {
+		i := strings.Index(body, s)
+		if i < last {
+			t.Errorf("%s out of order in: %s", s, body)
+		}
+		last = i
+	}
+	if !strings.Contains(body, `<td class="size">2.9 KB</td>`) {
+		t.Errorf("missing human-readable size: %s", body)
+	}
+	// Column links keep ?list so the index file doesn't take over, and
+	// clicking the current column reverses it.
+	if !strings.Contains(body, `<a href="?list=&amp;order=asc&amp;sort=size">Size ↓</a>`) {
+		t.Errorf("size column link wrong: %s", body)
+	}
+	if !strings.Contains(body, `<a href="?list=&amp;order=desc&amp;sort=mtime">Modified</a>`) {
+		t.Errorf("mtime column should sort newest first: %s", body)
+	}
+}
+
+func TestServeDirListJSON(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	mod := time.Date(2024, 3, 15, 12, 30, 0, 0, time.UTC)
+	writeFile(t, dir, "index.html", "<h1>custom</h1>", mod)
+	writeFile(t, dir, "my doc.md", "# Doc\n", mod)
+	writeFile(t, dir, "a/b/c/deep.txt", "deep", mod)
+	writeFile(t, dir, ".git/HEAD", "ref", mod)
+
+	type entry struct {
+		Name, Path, Type string
+		Size             int64
+		MTime            time.Time
+		URLs             struct{ Raw, Rendered, Download string }
+		Entries          []entry
+	}
+	list := func(target string, header bool) []entry {
+		t.Helper()
+		req := httptest.NewRequest("GET", target, nil)
+		if header {
+			req.Header.Set("Accept", "application/json")
+		}
+		rec := httptest.NewRecorder()
+		if !serveDirList(rec, req, "/") {
+			t.Fatalf("%s: serveDirList returned false despite index.html", target)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("%s: Content-Type = %q", target, ct)
+		}
+		var resp struct {
+			Path    string
+			Entries []entry
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Entries
+	}
+
+	top := list("/", true)
+	if len(top) != 4 || top[0].Name != ".git" || top[1].Name != "a" || top[0].Entries != nil {
+		t.Fatalf("unexpected top level: %+v", top)
+	}
+	doc := top[3]
+	if doc.Name != "my doc.md" || doc.Type != "markdown" || doc.Size != 6 || !doc.MTime.Equal(mod) {
+		t.Errorf("markdown entry = %+v", doc)
+	}
+	if doc.URLs.Raw != "/my%20doc.md?raw" || doc.URLs.Rendered != "/my%20doc.md" || doc.URLs.Download != "/my%20doc.md?download" {
+		t.Errorf("markdown URLs = %+v", doc.URLs)
+	}
+	if a := top[1]; a.Type != "dir" || a.URLs.Download != "/a/?export" {
+		t.Errorf("dir entry = %+v", a)
+	}
+
+	deep := list("/?format=json&recursive", false)
+	if deep[0].Entries != nil {
+		t.Error("recursive listing should not descend into .git")
+	}
+	c := deep[1].Entries[0].Entries[0]
+	if c.Path != "/a/b/c/" || len(c.Entries) != 1 || c.Entries[0].URLs.Raw != "/a/b/c/deep.txt" {
+		t.Errorf("recursive listing: %+v", c)
+	}
+
+	limited := list("/?format=json&depth=2", false)
+	if b := limited[1].Entries[0]; b.Name != "b" || b.Entries != nil {
+		t.Errorf("depth=2 should stop below a/b: %+v", b)
+	}
+
+	// A symlinked directory is listed, but not descended into.
+	if err := os.Symlink("..", filepath.Join(dir, "a", "self")); err != nil {
+		t.Skip(err)
+	}
+	deep = list("/?format=json&recursive", false)
+	if self := deep[1].Entries[1]; self.Name != "self" || self.Type != "dir" || self.Entries != nil {
+		t.Errorf("symlinked dir: %+v", self)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// buildVersion identifies the running binary, and with it the page
+// templates and built-in CSS, so that rebuilding serve changes every ETag.
+var buildVersion = sync.OnceValue(func() string {
+	h := sha256.New()
+	if exe, err := os.Executable(); err == nil {
+		if info, err := os.Stat(exe); err == nil {
+			h.Write([]byte(exe + "\x00" + strconv.FormatInt(info.ModTime().UnixNano(), 10) + "\x00"))
+		}
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		h.Write([]byte(bi.String()))
+	}
+	h.Write([]byte(markdownCSS + highlightCSS))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:12])
+})
+
+// contentETag returns a strong ETag for a response built from parts, which
+// are hashed along with the build and custom CSS versions.
+func contentETag(parts ...[]byte) string {
+	h := sha256.New()
+	for _, p := range append([][]byte{[]byte(buildVersion()), []byte(cssVersion())}, parts...) {
+		h.Write([]byte(strconv.Itoa(len(p)) + ":"))
+		h.Write(p)
+	}
+	return `"` + base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:18]) + `"`
+}
+
+// notModified answers a conditional GET or HEAD for a response with the
+// given ETag and modification time. If the client's copy is current it
+// writes a 304 and returns true. As in http.ServeContent, If-None-Match
+// takes precedence over If-Modified-Since.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, mod time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	match := false
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for tag := range strings.SplitSeq(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				match = true
+				break
+			}
+		}
+	} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !mod.IsZero() {
+		match = !mod.Truncate(time.Second).After(ims)
+	}
+	if !match {
+		return false
+	}
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Cache-Control", "no-cache")
+	if !mod.IsZero() {
+		h.Set("Last-Modified", mod.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// serveRendered writes body, a page built for this request, with validators
+// so that the browser can revalidate it cheaply next time. The caller sets
+// Content-Type.
+func serveRendered(w http.ResponseWriter, r *http.Request, etag string, mod time.Time, body []byte) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", mod, bytes.NewReader(body))
+}
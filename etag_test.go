@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConditionalGet(t *testing.T) {
+	useRenderCache(t, newRenderCache(1<<20))
+	dir := t.TempDir()
+	t.Chdir(dir)
+	mod := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	writeFile(t, dir, "docs/a.md", "# A\n", mod)
+	if err := os.Chtimes(filepath.Join(dir, "docs"), mod, mod); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(serve func(http.ResponseWriter, *http.Request, string) bool, path string, header ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		if !serve(rec, req, req.URL.Path) {
+			t.Fatalf("%s not served", path)
+		}
+		return rec
+	}
+
+	for _, tc := range []struct {
+		name  string
+		serve func(http.ResponseWriter, *http.Request, string) bool
+		path  string
+	}{
+		{"markdown", serveMarkdown, "/docs/a.md"},
+		{"listing", serveDirList, "/docs/"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := get(tc.serve, tc.path)
+			etag := rec.Header().Get("ETag")
+			if rec.Code != http.StatusOK || len(etag) < 3 || etag[0] != '"' {
+				t.Fatalf("code = %d, ETag = %q", rec.Code, etag)
+			}
+			if got := rec.Header().Get("Last-Modified"); got != mod.Format(http.TimeFormat) {
+				t.Errorf("Last-Modified = %q, want %q", got, mod.Format(http.TimeFormat))
+			}
+			if rec := get(tc.serve, tc.path, "If-None-Match", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+				t.Errorf("If-None-Match: code = %d, body %d bytes", rec.Code, rec.Body.Len())
+			}
+			if rec := get(tc.serve, tc.path, "If-Modified-Since", mod.Format(http.TimeFormat)); rec.Code != http.StatusNotModified {
+				t.Errorf("If-Modified-Since: code = %d", rec.Code)
+			}
+			if rec := get(tc.serve, tc.path, "If-None-Match", `"stale"`, "If-Modified-Since", mod.Format(http.TimeFormat)); rec.Code != http.StatusOK {
+				t.Errorf("stale ETag: code = %d, want 200", rec.Code)
+			}
+		})
+	}
+
+	// Editing the page or the custom CSS changes its ETag.
+	etag := get(serveMarkdown, "/docs/a.md").Header().Get("ETag")
+	writeFile(t, dir, "docs/a.md", "# A, edited\n", mod.Add(time.Minute))
+	edited := get(serveMarkdown, "/docs/a.md", "If-None-Match", etag)
+	if edited.Code != http.StatusOK || edited.Header().Get("ETag") == etag {
+		t.Errorf("after edit: code = %d, ETag %s unchanged", edited.Code, etag)
+	}
+	etag = edited.Header().Get("ETag")
+	old := customCSS
+	customCSS = "body { color: red }"
+	t.Cleanup(func() { customCSS = old })
+	if rec := get(serveMarkdown, "/docs/a.md", "If-None-Match", etag); rec.Code != http.StatusOK {
+		t.Errorf("after CSS change: code = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// exportWriter receives the files of an export: a zip or tar.gz streamed
+// to the client, or a directory on disk.
+type exportWriter interface {
+	// Add writes the file name (slash-separated, relative to the export
+	// root) with size bytes read from r.
+	Add(name string, mod time.Time, size int64, r io.Reader) error
+	// Close finishes the export. It is not called if adding files failed.
+	Close() error
+}
+
+// exportFormats maps ?export= values to their archive formats. A bare
+// ?export means zip.
+var exportFormats = map[string]struct {
+	ext, contentType string
+	newWriter        func(io.Writer) exportWriter
+}{
+	"":       {".zip", "application/zip", newZipExport},
+	"zip":    {".zip", "application/zip", newZipExport},
+	"tar.gz": {".tar.gz", "application/gzip", newTarGzExport},
+	"tgz":    {".tar.gz", "application/gzip", newTarGzExport},
+}
+
+type zipExport struct{ zw *zip.Writer }
+
+func newZipExport(w io.Writer) exportWriter { return zipExport{zip.NewWriter(w)} }
+
+func (z zipExport) Add(name string, mod time.Time, size int64, r io.Reader) error {
+	f, err := zipEntry(z.zw, name, mod)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func (z zipExport) Close() error { return z.zw.Close() }
+
+type tarGzExport struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarGzExport(w io.Writer) exportWriter {
+	gz := gzip.NewWriter(w)
+	return tarGzExport{gz, tar.NewWriter(gz)}
+}
+
+func (t tarGzExport) Add(name string, mod time.Time, size int64, r io.Reader) error {
+	if err := t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  mod,
+		Format:   tar.FormatPAX,
+	}); err != nil {
+		return err
+	}
+	// The header promised size bytes, so write exactly that many.
+	n, err := io.CopyN(t.tw, r, size)
+	if err == io.EOF {
+		return fmt.Errorf("%s: got %d bytes, want %d", name, n, size)
+	}
+	return err
+}
+
+func (t tarGzExport) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
+
+// dirExport writes an export into a directory, creating it if needed and
+// overwriting files already there.
+type dirExport struct{ dir string }
+
+func (d dirExport) Add(name string, mod time.Time, size int64, r io.Reader) error {
+	p := filepath.Join(d.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if mod.IsZero() {
+		return nil
+	}
+	return os.Chtimes(p, mod, mod)
+}
+
+func (dirExport) Close() error { return nil }
+
+// contains reports whether the export directory is p or inside it, so a
+// site exported into the tree it's built from doesn't export itself.
+func (d dirExport) contains(p string) bool {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(d.dir, abs)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// writeExport adds the tree at root to ew as a static site: markdown
+// rendered to standalone HTML pages with site navigation and prev/next
+// links, an index.html listing each directory that has no index page, and
+// every other file as is. It stops early with ctx's error once ctx is done.
+func writeExport(ctx context.Context, ew exportWriter, root string) error {
+	site, err := scanExport(ctx, root, ew)
+	if err != nil {
+		return err
+	}
+
+	// Rendered pages are indexed for the search box on each of them.
+	var searchEntries []exportSearchEntry
+	var lastMod time.Time
+	for i, p := range site.pages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		html, source, err := site.renderPage(i)
+		if err != nil {
+			return err
+		}
+		if err := ew.Add(p.out, p.mod, int64(len(html)), bytes.NewReader(html)); err != nil {
+			return err
+		}
+		searchEntries = append(searchEntries, newExportSearchEntry(p.out, p.title, source))
+		if p.mod.After(lastMod) {
+			lastMod = p.mod
+		}
+	}
+
+	for _, d := range site.dirs {
+		if d.index == nil && !d.ownIndex {
+			html, err := site.renderListing(d)
+			if err != nil {
+				return err
+			}
+			if err := ew.Add(path.Join(d.rel, "index.html"), d.mod, int64(len(html)), bytes.NewReader(html)); err != nil {
+				return err
+			}
+		}
+		for _, f := range d.files {
+			if err := exportFileTo(ctx, ew, f); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(searchEntries) > 0 {
+		return writeExportSearchIndex(ew, searchEntries, lastMod)
+	}
+	return nil
+}
+
+func exportFileTo(ctx context.Context, ew exportWriter, f exportFile) error {
+	src, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	// The walk saw symlinks themselves and the file may have changed
+	// since, so go by what was opened.
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil // a link to a directory, a device, or the like
+	}
+	return ew.Add(f.rel, info.ModTime(), info.Size(), contextReader{ctx, src})
+}
+
+// runExport implements "serve export <dir> <outdir>", writing the rendered
+// site for dir into outdir just as ?export would zip it. It returns the
+// process exit code.
+func runExport(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: serve export <dir> <outdir>")
+		return 2
+	}
+	src, out := args[0], args[1]
+	if info, err := os.Stat(src); err != nil || !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "serve export: %s is not a directory\n", src)
+		return 1
+	}
+	abs, err := filepath.Abs(out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "serve export: %v\n", err)
+		return 1
+	}
+	// Exporting into dir's own subdirectory is fine (it's skipped), but
+	// not over dir or a parent of it, where copies would clobber sources.
+	dest := dirExport{abs}
+	if dest.contains(src) {
+		fmt.Fprintf(os.Stderr, "serve export: %s must not contain %s\n", out, src)
+		return 1
+	}
+	if css, err := os.ReadFile(filepath.Join(*dataDir, "custom.css")); err == nil {
+		customCSS = string(css)
+	}
+	if err := writeExport(context.Background(), dest, src); err != nil {
+		fmt.Fprintf(os.Stderr, "serve export: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "exported %s to %s\n", src, out)
+	return 0
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeExportTarGz(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	mod := time.Date(2024, 3, 15, 12, 30, 0, 0, time.UTC)
+	writeFile(t, dir, "README.md", "See [guide](docs/guide.md).\n", mod)
+	writeFile(t, dir, "docs/guide.md", "# Guide\n", mod)
+	writeFile(t, dir, "logo.png", "PNGBYTES", mod)
+
+	rec := httptest.NewRecorder()
+	serveExport(rec, httptest.NewRequest("GET", "/?export=tar.gz", nil), "/")
+	if ct := rec.Header().Get("Content-Type"); ct != "application/gzip" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.HasSuffix(cd, `.tar.gz"`) {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[h.Name] = string(b)
+		if h.Name == "logo.png" && !h.ModTime.Equal(mod) {
+			t.Errorf("logo.png mod time = %v, want %v", h.ModTime, mod)
+		}
+	}
+	if files["logo.png"] != "PNGBYTES" || files["docs/guide.html"] == "" || files[exportSearchFile] == "" {
+		t.Errorf("missing files in tar: %v", len(files))
+	}
+	if !strings.Contains(files["index.html"], `href="docs/guide.html"`) {
+		t.Error("links not rewritten in tar export")
+	}
+}
+
+func TestServeExportTarGzSymlink(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "assets/logo-full-size.png", "PNGBYTES, MANY MORE THAN THE LINK", time.Time{})
+	if err := os.Symlink("assets/logo-full-size.png", filepath.Join(dir, "logo.png")); err != nil {
+		t.Skip(err)
+	}
+
+	rec := httptest.NewRecorder()
+	serveExport(rec, httptest.NewRequest("GET", "/?export=tar.gz", nil), "/")
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading tar: %v", err)
+		}
+		b, _ := io.ReadAll(tr)
+		files[h.Name] = string(b)
+	}
+	if files["logo.png"] != "PNGBYTES, MANY MORE THAN THE LINK" {
+		t.Errorf("logo.png = %q, want the target's contents", files["logo.png"])
+	}
+}
+
+func TestServeExportUnknownFormat(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	rec := httptest.NewRecorder()
+	serveExport(rec, httptest.NewRequest("GET", "/?export=rar", nil), "/")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
+
+func TestRunExport(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	mod := time.Date(2024, 3, 15, 12, 30, 0, 0, time.UTC)
+	writeFile(t, dir, "docs/README.md", "See [guide](guide.md#top).\n", mod)
+	writeFile(t, dir, "docs/guide.md", "# Guide\n", mod)
+	writeFile(t, dir, "docs/img/logo.png", "PNGBYTES", mod)
+
+	// The output may live inside the exported tree; it isn't exported again.
+	if code := runExport([]string{"docs", "docs/site"}); code != 0 {
+		t.Fatalf("runExport = %d", code)
+	}
+	if code := runExport([]string{"docs", "docs/site"}); code != 0 {
+		t.Fatalf("second runExport = %d", code)
+	}
+	if _, err := os.Stat("docs/site/site"); err == nil {
+		t.Error("export copied its own output directory")
+	}
+	html, err := os.ReadFile("docs/site/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(html), `href="guide.html#top"`) {
+		t.Errorf("links not rewritten: %s", html)
+	}
+	info, err := os.Stat(filepath.FromSlash("docs/site/img/logo.png"))
+	if err != nil || !info.ModTime().Equal(mod) {
+		t.Errorf("asset not copied with its mod time: %v %v", info, err)
+	}
+
+	if code := runExport([]string{"docs", "."}); code != 1 {
+		t.Errorf("exporting over a parent of the source = %d, want 1", code)
+	}
+	if code := runExport([]string{"docs"}); code != 2 {
+		t.Errorf("missing argument = %d, want 2", code)
+	}
+}
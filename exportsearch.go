@@ -0,0 +1,57 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// exportSearchFile holds the search index of a folder export. It's a script
+// assigning the JSON index to a global rather than a bare .json file because
+// browsers won't fetch() files next to a page opened from disk.
+const exportSearchFile = "search-index.js"
+
+// exportSearchEntry is one exported page in the search index.
+type exportSearchEntry struct {
+	URL   string `json:"url"` // relative to the export root
+	Title string `json:"title"`
+	Text  string `json:"text"`
+}
+
+// newExportSearchEntry indexes the markdown source of the page exported
+// as htmlRel. Results are titled by the page's first heading when it has
+// one, which reads better in a list than a file name.
+func newExportSearchEntry(htmlRel, title string, source []byte) exportSearchEntry {
+	heading, body := markdownText(source)
+	if heading != "" {
+		title = heading
+	}
+	return exportSearchEntry{
+		URL:   htmlRel,
+		Title: title,
+		Text:  strings.Join(strings.Fields(body), " "),
+	}
+}
+
+// writeExportSearchIndex adds exportSearchFile to the root of an export.
+func writeExportSearchIndex(ew exportWriter, entries []exportSearchEntry, mod time.Time) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	// json.Marshal escapes <, > and &, so the data can't close a script.
+	js := "var serveSearchIndex = " + string(data) + ";\n"
+	return ew.Add(exportSearchFile, mod, int64(len(js)), strings.NewReader(js))
+}
+
+// exportRoot returns the relative URL of the export root from the page at
+// rel, such as "./" or "../../".
+func exportRoot(rel string) string {
+	if n := strings.Count(rel, "/"); n > 0 {
+		return strings.Repeat("../", n)
+	}
+	return "./"
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeExportSearchIndex(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "README.md", "# Home\n\nWelcome a < b.\n", time.Time{})
+	writeFile(t, dir, "docs/guide.md", "# Guide\n\nSome **setup** steps.\n", time.Time{})
+	writeFile(t, dir, "docs/wip.md", "---\ndraft: true\n---\n# Secret\n", time.Time{})
+	// The export's own index replaces a file of the same name.
+	writeFile(t, dir, exportSearchFile, "alert(1);\n", time.Time{})
+
+	rec := httptest.NewRecorder()
+	if !serveExport(rec, httptest.NewRequest("GET", "/?export", nil), "/") {
+		t.Fatal("serveExport returned false")
+	}
+	files := readZip(t, rec.Body.Bytes())
+
+	f := files[exportSearchFile]
+	if f == nil {
+		t.Fatalf("missing %s", exportSearchFile)
+	}
+	js := zipBody(t, f)
+	data, ok := strings.CutPrefix(strings.TrimSpace(js), "var serveSearchIndex = ")
+	if !ok || strings.Contains(js, "<") {
+		t.Fatalf("unexpected index script: %s", js)
+	}
+	var entries []exportSearchEntry
+	if err := json.Unmarshal([]byte(strings.TrimSuffix(data, ";")), &entries); err != nil {
+		t.Fatal(err)
+	}
+	want := []exportSearchEntry{
+		{URL: "index.html", Title: "Home", Text: "Home Welcome a < b."},
+		{URL: "docs/guide.html", Title: "Guide", Text: "Guide Some setup steps."},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("entries = %+v, want %+v", entries, want)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+
+	guide := zipBody(t, files["docs/guide.html"])
+	if !strings.Contains(guide, `<script src="../search-index.js"></script>`) || !strings.Contains(guide, `var root = "../";`) {
+		t.Errorf("nested page doesn't load the index relative to the root: %s", guide)
+	}
+	if !strings.Contains(zipBody(t, files["index.html"]), `<script src="./search-index.js"></script>`) {
+		t.Error("root page doesn't load the index")
+	}
+}
+
+func TestServeMarkdownDownloadHasNoSearch(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "a.md", "# A\n", time.Time{})
+
+	rec := httptest.NewRecorder()
+	serveMarkdown(rec, httptest.NewRequest("GET", "/a.md?download", nil), "/a.md")
+	if html := zipBody(t, readZip(t, rec.Body.Bytes())["a.html"]); strings.Contains(html, `class="export-search"`) {
+		t.Error("single-page download has no index to search")
+	}
+}
+
+func TestExportRoot(t *testing.T) {
+	for rel, want := range map[string]string{"a.md": "./", "x/a.md": "../", "x/y/a.md": "../../"} {
+		if got := exportRoot(rel); got != want {
+			t.Errorf("exportRoot(%q) = %q, want %q", rel, got, want)
+		}
+	}
+}
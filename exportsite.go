@@ -0,0 +1,428 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"html"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// exportSite is a tree being exported, gathered before anything is written
+// so that every page can link to every other. Only what navigation needs is
+// kept; pages are rendered one at a time as they're written.
+type exportSite struct {
+	root  string
+	name  string        // the root directory's name
+	dirs  []*exportDir  // in walk order; dirs[0] is the root
+	pages []*exportPage // markdown in reading order
+	wiki  []string      // pages [[links]] may name: all but drafts
+
+	outs map[string]string // by rel, pages exported under another name than links to them assume
+}
+
+type exportDir struct {
+	rel      string // slash-separated, "" for the root
+	name     string
+	mod      time.Time
+	index    *exportPage // the -index file, exported as index.html
+	ownIndex bool        // the directory has its own index.html
+	pages    []*exportPage
+	files    []exportFile
+	subdirs  []*exportDir
+	inNav    bool // it or a subdirectory has pages
+}
+
+type exportPage struct {
+	rel     string // of the markdown source
+	out     string // output path, e.g. "docs/guide.html"
+	title   string // of the HTML document
+	heading string // front matter title or first top-level heading, if any
+	mod     time.Time
+	size    int64 // of the markdown source
+}
+
+// label is how navigation refers to the page: by its first heading, like
+// search results do, or failing that its title.
+func (p *exportPage) label() string { return cmp.Or(p.heading, p.title) }
+
+// exportFile is a file exported as it is.
+type exportFile struct {
+	path string
+	rel  string
+	mod  time.Time
+	size int64
+}
+
+// exportLink is a prev/next link between exported pages.
+type exportLink struct {
+	Title string
+	Href  string
+}
+
+// scanExport walks root, reading the front matter and first heading of its
+// markdown. Directories that ew itself is writing into are skipped.
+func scanExport(ctx context.Context, root string, ew exportWriter) (*exportSite, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	site := &exportSite{root: root, name: filepath.Base(abs)}
+	dirs := make(map[string]*exportDir)
+
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rel = ""
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		parent := dirs[parentRel(rel)]
+
+		if d.IsDir() {
+			if p != root && skippedDir(d.Name()) {
+				return fs.SkipDir
+			}
+			if out, ok := ew.(dirExport); ok && p != root && out.contains(p) {
+				return fs.SkipDir
+			}
+			dir := &exportDir{rel: rel, name: d.Name(), mod: info.ModTime()}
+			if p == root {
+				dir.name = site.name
+			} else {
+				parent.subdirs = append(parent.subdirs, dir)
+			}
+			dirs[rel] = dir
+			site.dirs = append(site.dirs, dir)
+			return nil
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			// Export what the link points at, if it's a file; a broken
+			// link fails the export when it's opened.
+			if target, err := os.Stat(p); err == nil {
+				if !target.Mode().IsRegular() {
+					return nil
+				}
+				info = target
+			}
+		}
+		if rel == exportSearchFile {
+			// The export's own search index goes here.
+			log.Printf("export: leaving out %s, whose name the search index needs", p)
+			return nil
+		}
+		if !strings.HasSuffix(strings.ToLower(d.Name()), ".md") {
+			parent.files = append(parent.files, exportFile{p, rel, info.ModTime(), info.Size()})
+			if d.Name() == "index.html" {
+				parent.ownIndex = true
+			}
+			return nil
+		}
+
+		source, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		meta, heading := pageHeading(source)
+		if meta.Draft {
+			return nil
+		}
+		site.wiki = append(site.wiki, rel)
+		ep := &exportPage{
+			rel:     rel,
+			out:     strings.TrimSuffix(rel, filepath.Ext(rel)) + ".html",
+			title:   cmp.Or(meta.Title, strings.TrimSuffix(d.Name(), filepath.Ext(d.Name()))),
+			heading: cmp.Or(meta.Title, heading),
+			mod:     info.ModTime(),
+			size:    info.Size(),
+		}
+		if *index != "" && d.Name() == *index {
+			ep.out = path.Join(parent.rel, "index.html")
+			parent.index = ep
+		} else {
+			parent.pages = append(parent.pages, ep)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Files keep their names, and each directory's index.html is its own,
+	// its index file, or its listing, in that order. A directory's own
+	// index.html leaves its index file its usual name, and a page whose
+	// name is taken, like index.md beside the index file or foo.md beside
+	// foo.html, is exported with .html added to its own.
+	site.outs = make(map[string]string)
+	for _, d := range site.dirs {
+		taken := map[string]bool{"index.html": true}
+		for _, f := range d.files {
+			taken[path.Base(f.rel)] = true
+		}
+		if d.ownIndex && d.index != nil {
+			d.index.out = strings.TrimSuffix(d.index.rel, filepath.Ext(d.index.rel)) + ".html"
+			d.pages = append([]*exportPage{d.index}, d.pages...)
+			d.index = nil
+			site.outs[d.pages[0].rel] = d.pages[0].out
+		}
+		for _, p := range d.pages {
+			if taken[path.Base(p.out)] {
+				p.out = p.rel + ".html"
+				site.outs[p.rel] = p.out
+			}
+			if taken[path.Base(p.out)] {
+				return nil, fmt.Errorf("%s: %s is taken too; rename it", p.rel, p.out)
+			}
+			taken[path.Base(p.out)] = true
+		}
+	}
+	site.order(site.dirs[0])
+	return site, nil
+}
+
+// pageHeading returns the front matter and first top-level heading of the
+// markdown source, parsing it without rendering it.
+func pageHeading(source []byte) (meta pageMeta, heading string) {
+	pc := parser.NewContext()
+	doc := md.Parser().Parse(text.NewReader(source), parser.WithContext(pc))
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if h, ok := n.(*ast.Heading); ok && entering && h.Level == 1 {
+			heading = headingText(h, source)
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return pageMetaFrom(pc), heading
+}
+
+func parentRel(rel string) string {
+	if i := strings.LastIndex(rel, "/"); i >= 0 {
+		return rel[:i]
+	}
+	return ""
+}
+
+// order appends the pages under d to s.pages in reading order: the index,
+// the other pages, then each subdirectory in turn. It reports whether there
+// were any.
+func (s *exportSite) order(d *exportDir) bool {
+	n := len(s.pages)
+	if d.index != nil {
+		s.pages = append(s.pages, d.index)
+	}
+	s.pages = append(s.pages, d.pages...)
+	for _, sub := range d.subdirs {
+		s.order(sub)
+	}
+	d.inNav = len(s.pages) > n
+	return d.inNav
+}
+
+func (d *exportDir) title() string {
+	if d.index != nil {
+		return d.index.label()
+	}
+	return d.name
+}
+
+// exportHref returns the link from the exported page at from to the one at
+// to, both relative to the export root.
+func exportHref(from, to string) string {
+	return exportRoot(from) + (&url.URL{Path: to}).String()
+}
+
+// nav renders the site-wide navigation for the page at current: every
+// directory with pages, linked to its index, with its pages nested below.
+func (s *exportSite) nav(current string) template.HTML {
+	var b strings.Builder
+	b.WriteString("<ul>\n<li>")
+	s.navDir(&b, s.dirs[0], current)
+	b.WriteString("</li>\n</ul>\n")
+	return template.HTML(b.String())
+}
+
+func (s *exportSite) navDir(b *strings.Builder, d *exportDir, current string) {
+	navLink(b, current, path.Join(d.rel, "index.html"), d.title())
+	if len(d.pages) == 0 && !hasNavSubdirs(d) {
+		return
+	}
+	b.WriteString("<ul>\n")
+	for _, p := range d.pages {
+		b.WriteString("<li>")
+		navLink(b, current, p.out, p.label())
+		b.WriteString("</li>\n")
+	}
+	for _, sub := range d.subdirs {
+		if sub.inNav {
+			b.WriteString("<li>")
+			s.navDir(b, sub, current)
+			b.WriteString("</li>\n")
+		}
+	}
+	b.WriteString("</ul>\n")
+}
+
+func hasNavSubdirs(d *exportDir) bool {
+	for _, sub := range d.subdirs {
+		if sub.inNav {
+			return true
+		}
+	}
+	return false
+}
+
+func navLink(b *strings.Builder, current, target, title string) {
+	b.WriteString(`<a href="` + html.EscapeString(exportHref(current, target)) + `"`)
+	if target == current {
+		b.WriteString(` aria-current="page"`)
+	}
+	b.WriteString(">" + html.EscapeString(title) + "</a>")
+}
+
+// renderPage renders the i'th page in reading order, returning its HTML
+// and its markdown with includes expanded.
+func (s *exportSite) renderPage(i int) (html, source []byte, err error) {
+	p := s.pages[i]
+	rendered, err := renderFile(s.root, p.rel, &wikiPage{rel: p.rel, pages: s.wiki})
+	if err != nil {
+		return nil, nil, err
+	}
+	var prev, next *exportLink
+	if i > 0 {
+		prev = &exportLink{s.pages[i-1].label(), exportHref(p.out, s.pages[i-1].out)}
+	}
+	if i+1 < len(s.pages) {
+		next = &exportLink{s.pages[i+1].label(), exportHref(p.out, s.pages[i+1].out)}
+	}
+	html, err = s.execute(p.out, p.title, rendered.page, prev, next)
+	return html, rendered.source, err
+}
+
+// renderListing renders the index.html of a directory that has no index of
+// its own, listing it the way serveDirList does.
+func (s *exportSite) renderListing(d *exportDir) ([]byte, error) {
+	out := path.Join(d.rel, "index.html")
+	var list []listEntry
+	for _, sub := range d.subdirs {
+		list = append(list, listEntry{
+			Name:    sub.name + "/",
+			Href:    (&url.URL{Path: sub.name + "/index.html"}).String(),
+			IsDir:   true,
+			ModTime: sub.mod,
+			Type:    "dir",
+		})
+	}
+	for _, p := range d.pages {
+		name := path.Base(p.out)
+		list = append(list, listEntry{
+			Name:    name,
+			Href:    (&url.URL{Path: name}).String(),
+			Size:    p.size,
+			ModTime: p.mod,
+			Type:    "markdown",
+		})
+	}
+	for _, f := range d.files {
+		name := path.Base(f.rel)
+		list = append(list, listEntry{
+			Name:    name,
+			Href:    (&url.URL{Path: name}).String(),
+			Size:    f.size,
+			ModTime: f.mod,
+			Type:    fileType(name),
+		})
+	}
+	sortEntries(list, listSort{By: "name"})
+
+	// Breadcrumbs lead back up through each parent's index.html.
+	var crumbs []crumb
+	parts := []string{s.name}
+	if d.rel != "" {
+		parts = append(parts, strings.Split(d.rel, "/")...)
+	}
+	for i, name := range parts {
+		c := crumb{Name: name}
+		if up := len(parts) - 1 - i; up > 0 {
+			c.Href = strings.Repeat("../", up) + "index.html"
+		}
+		crumbs = append(crumbs, c)
+	}
+
+	var content bytes.Buffer
+	if err := exportDirTemplate.Execute(&content, struct {
+		Crumbs  []crumb
+		Entries []listEntry
+	}{crumbs, list}); err != nil {
+		return nil, err
+	}
+	page := &renderedMarkdown{Content: template.HTML(content.String())}
+	return s.execute(out, d.name, page, nil, nil)
+}
+
+// execute renders page as the standalone HTML file out.
+func (s *exportSite) execute(out, title string, page *renderedMarkdown, prev, next *exportLink) ([]byte, error) {
+	var htmlBuf bytes.Buffer
+	if err := mdTemplateStandalone.Execute(&htmlBuf, struct {
+		Title      string
+		BaseCSS    template.CSS
+		Content    template.HTML
+		TOC        template.HTML
+		Meta       pageMeta
+		CustomCSS  template.CSS
+		SearchRoot string
+		Nav        template.HTML
+		Prev, Next *exportLink
+	}{
+		Title:      title,
+		BaseCSS:    template.CSS(markdownCSS + highlightCSS),
+		Content:    page.Content,
+		TOC:        page.TOC,
+		Meta:       page.Meta,
+		CustomCSS:  template.CSS(customCSS),
+		SearchRoot: exportRoot(out),
+		Nav:        s.nav(out),
+		Prev:       prev,
+		Next:       next,
+	}); err != nil {
+		return nil, err
+	}
+	return rewriteMarkdownLinks(inlineMermaidJS(htmlBuf.Bytes()), out, s.outs), nil
+}
+
+var exportDirTemplate = template.Must(template.New("export-dir").Parse(`<h1 class="crumbs">{{range .Crumbs}}{{if .Href}}<a href="{{.Href}}">{{.Name}}</a>{{else}}<span>{{.Name}}</span>{{end}}{{end}}</h1>
+<table class="dir">
+<thead><tr><th class="name">Name</th><th class="size">Size</th><th class="mtime">Modified</th></tr></thead>
+<tbody>
+{{range .Entries}}<tr><td class="name"><span class="icon" aria-hidden="true">{{.Icon}}</span><a href="{{.Href}}">{{.Name}}</a></td><td class="size">{{.SizeText}}</td><td class="mtime">{{.Modified}}</td></tr>
+{{end}}</tbody>
+</table>
+`))
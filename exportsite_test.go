@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportSite(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "src/README.md", "# Home\n\nSee the [docs](docs/) and [own](own/README.md).\n", time.Time{})
+	writeFile(t, dir, "src/a.md", "# Alpha\n", time.Time{})
+	writeFile(t, dir, "src/docs/guide.md", "# Guide\n", time.Time{})
+	writeFile(t, dir, "src/assets/logo.png", "PNGBYTES", time.Time{})
+	writeFile(t, dir, "src/own/index.html", "mine", time.Time{})
+	writeFile(t, dir, "src/own/README.md", "# Own\n", time.Time{})
+
+	if err := writeExport(context.Background(), dirExport{filepath.Join(dir, "out")}, "src"); err != nil {
+		t.Fatal(err)
+	}
+	read := func(name string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(dir, "out", filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	home := read("index.html")
+	if !strings.Contains(home, `href="docs/index.html"`) {
+		t.Errorf("directory link not rewritten to its index: %s", home)
+	}
+
+	// Directories without an index page get a listing.
+	docs := read("docs/index.html")
+	for _, want := range []string{`<a href="guide.html">guide.html</a>`, `<a href="../index.html">src</a>`, `class="dir"`} {
+		if !strings.Contains(docs, want) {
+			t.Errorf("docs listing missing %s: %s", want, docs)
+		}
+	}
+	if !strings.Contains(read("assets/index.html"), `<a href="logo.png">logo.png</a>`) {
+		t.Error("assets listing missing logo.png")
+	}
+
+	// A directory's own index.html wins over its README.
+	if got := read("own/index.html"); got != "mine" {
+		t.Errorf("own/index.html = %q", got)
+	}
+	read("own/README.html")
+	if !strings.Contains(home, `href="own/README.html"`) {
+		t.Errorf("link to own/README.md not rewritten to README.html: %s", home)
+	}
+
+	// Every page has the whole site's navigation, marking itself.
+	guide := read("docs/guide.html")
+	for _, want := range []string{
+		`<a href="../index.html">Home</a>`,
+		`<a href="../docs/guide.html" aria-current="page">Guide</a>`,
+		`<a href="../own/README.html">Own</a>`,
+	} {
+		if !strings.Contains(guide, want) {
+			t.Errorf("guide nav missing %s", want)
+		}
+	}
+	if strings.Contains(guide, "assets/index.html") {
+		t.Error("nav lists a directory without pages")
+	}
+
+	// Pages link to their neighbours in reading order.
+	a := read("a.html")
+	if !strings.Contains(a, `<a class="prev" href="./index.html">← Home</a><a class="next" href="./docs/guide.html">Guide →</a>`) {
+		t.Errorf("a.html pager wrong: %s", a)
+	}
+	if strings.Contains(home, `class="prev"`) || strings.Contains(read("own/README.html"), `class="next"`) {
+		t.Error("first or last page links past the ends")
+	}
+}
+
+func TestScanExport(t *testing.T) {
+	useRenderCache(t, newRenderCache(1<<20))
+	dir := t.TempDir()
+	writeFile(t, dir, "a.md", "---\ntitle: Alpha Page\n---\n# Alpha\n", time.Time{})
+	writeFile(t, dir, "b.md", "Intro.\n\n## Part\n\n# Beta\n", time.Time{})
+	writeFile(t, dir, "c.md", "No heading.\n", time.Time{})
+	writeFile(t, dir, "draft.md", "---\ndraft: true\n---\n# Draft\n", time.Time{})
+
+	site, err := scanExport(context.Background(), dir, dirExport{filepath.Join(dir, "out")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pages are only rendered as they're written.
+	if st := renderedPages.stats(); st.Entries != 0 || st.Misses != 0 {
+		t.Errorf("scan rendered pages: %+v", st)
+	}
+	var labels []string
+	for _, p := range site.pages {
+		labels = append(labels, p.label())
+	}
+	if got := strings.Join(labels, ","); got != "Alpha Page,Beta,c" {
+		t.Errorf("labels = %s", got)
+	}
+	if got := strings.Join(site.wiki, ","); got != "a.md,b.md,c.md" {
+		t.Errorf("wiki pages = %s, want drafts left out", got)
+	}
+}
+
+func TestExportSiteNameCollisions(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "src/README.md", "# Home\n\n[index](docs/index.md) [foo](foo.md)\n", time.Time{})
+	writeFile(t, dir, "src/foo.md", "# Foo page\n", time.Time{})
+	writeFile(t, dir, "src/foo.html", "foo file", time.Time{})
+	writeFile(t, dir, "src/docs/README.md", "# Docs home\n", time.Time{})
+	writeFile(t, dir, "src/docs/index.md", "# Docs index\n", time.Time{})
+	writeFile(t, dir, "src/list/index.md", "# List index\n", time.Time{})
+
+	if err := writeExport(context.Background(), dirExport{filepath.Join(dir, "out")}, "src"); err != nil {
+		t.Fatal(err)
+	}
+	// Pages give way to files and index pages, and are exported with
+	// .html added to their names instead.
+	for name, want := range map[string]string{
+		"foo.html":           "foo file",
+		"foo.md.html":        "Foo page",
+		"docs/index.html":    "Docs home",
+		"docs/index.md.html": "Docs index",
+		"list/index.md.html": "List index",
+		"list/index.html":    "index.md.html",
+		"index.html":         `href="docs/index.md.html"`,
+	} {
+		b, err := os.ReadFile(filepath.Join(dir, "out", filepath.FromSlash(name)))
+		if err != nil {
+			t.Error(err)
+		} else if !strings.Contains(string(b), want) {
+			t.Errorf("%s doesn't contain %s: %s", name, want, b)
+		}
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "out", "index.html")); !strings.Contains(string(b), `href="foo.md.html"`) {
+		t.Errorf("link to foo.md not rewritten to foo.md.html: %s", b)
+	}
+}
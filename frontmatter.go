@@ -0,0 +1,99 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"go.abhg.dev/goldmark/frontmatter"
+)
+
+// pageMeta is the subset of a document's YAML (---) or TOML (+++) front
+// matter that serve understands. Unknown keys are ignored.
+type pageMeta struct {
+	Title  string
+	Author string
+	Date   string
+	Tags   []string
+	Draft  bool
+}
+
+// pageMetaFrom decodes the front matter found while parsing with pc. A
+// document without front matter, or with front matter that doesn't decode,
+// yields the zero pageMeta so it still renders.
+func pageMetaFrom(pc parser.Context) pageMeta {
+	data := frontmatter.Get(pc)
+	if data == nil {
+		return pageMeta{}
+	}
+	var raw struct {
+		Title  string
+		Author any
+		Date   any // YAML and TOML both decode bare dates to their own types
+		Tags   any // a list, or a single comma-separated string
+		Draft  bool
+	}
+	if err := data.Decode(&raw); err != nil {
+		return pageMeta{}
+	}
+	return pageMeta{
+		Title:  strings.TrimSpace(raw.Title),
+		Author: strings.Join(stringList(raw.Author), ", "),
+		Date:   formatMetaDate(raw.Date),
+		Tags:   stringList(raw.Tags),
+		Draft:  raw.Draft,
+	}
+}
+
+// frontMatter decodes the front matter of source without parsing the rest
+// of the document.
+func frontMatter(source []byte) pageMeta {
+	head := source[:len(source)-len(stripFrontMatter(source))]
+	if len(head) == 0 {
+		return pageMeta{}
+	}
+	pc := parser.NewContext()
+	md.Parser().Parse(text.NewReader(head), parser.WithContext(pc))
+	return pageMetaFrom(pc)
+}
+
+func formatMetaDate(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		if v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 {
+			return v.Format("2006-01-02")
+		}
+		return v.Format("2006-01-02 15:04")
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// stringList flattens a front matter value that may be a list or a
+// comma-separated string into trimmed, non-empty strings.
+func stringList(v any) []string {
+	var out []string
+	switch v := v.(type) {
+	case string:
+		for s := range strings.SplitSeq(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	case []any:
+		for _, e := range v {
+			if s := strings.TrimSpace(fmt.Sprint(e)); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderMarkdownFrontMatter(t *testing.T) {
+	cases := map[string]string{
+		"yaml": "---\ntitle: Design Doc\nauthor: Ada\ndate: 2024-03-15\ntags: [infra, networking]\n---\n# Body\n",
+		"toml": "+++\ntitle = \"Design Doc\"\nauthor = \"Ada\"\ndate = 2024-03-15\ntags = [\"infra\", \"networking\"]\n+++\n# Body\n",
+	}
+	want := pageMeta{
+		Title:  "Design Doc",
+		Author: "Ada",
+		Date:   "2024-03-15",
+		Tags:   []string{"infra", "networking"},
+	}
+	for name, src := range cases {
+		page, err := renderMarkdown([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(page.Meta, want) {
+			t.Errorf("%s: Meta = %+v, want %+v", name, page.Meta, want)
+		}
+		if got := string(page.Content); strings.Contains(got, "<hr") || strings.Contains(got, "Ada") {
+			t.Errorf("%s: front matter leaked into body: %s", name, got)
+		}
+	}
+}
+
+func TestRenderMarkdownBadFrontMatterStillRenders(t *testing.T) {
+	page, err := renderMarkdown([]byte("---\ntitle: [unclosed\n---\n# Body\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Meta.Title != "" || !strings.Contains(string(page.Content), "Body") {
+		t.Errorf("got Meta %+v, Content %s", page.Meta, page.Content)
+	}
+}
+
+func TestServeMarkdownFrontMatterHeader(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "doc.md", "---\ntitle: Nice <Title>\nauthor: Ada\ntags: a, b\n---\nText\n", time.Time{})
+
+	rec := httptest.NewRecorder()
+	if !serveMarkdown(rec, httptest.NewRequest("GET", "/doc.md", nil), "/doc.md") {
+		t.Fatal("serveMarkdown returned false")
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "<title>Nice &lt;Title&gt;</title>") {
+		t.Errorf("front matter title not used: %s", body)
+	}
+	if !strings.Contains(body, `<span class="author">Ada</span>`) || !strings.Contains(body, `<span class="tag">b</span>`) {
+		t.Errorf("metadata header missing: %s", body)
+	}
+}
+
+func TestServeExportSkipsDrafts(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "done.md", "# Done\n", time.Time{})
+	writeFile(t, dir, "wip.md", "---\ndraft: true\n---\n# WIP\n", time.Time{})
+
+	rec := httptest.NewRecorder()
+	if !serveExport(rec, httptest.NewRequest("GET", "/?export", nil), "/") {
+		t.Fatal("serveExport returned false")
+	}
+	files := readZip(t, rec.Body.Bytes())
+	if files["done.html"] == nil {
+		t.Error("published page missing from export")
+	}
+	if files["wip.html"] != nil {
+		t.Error("draft page should be excluded from export")
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn"
+)
+
+const (
+	// funnelTokenParam carries a share link's token.
+	funnelTokenParam = "funnel-token"
+	// funnelCookie remembers the token once a visitor has used the link.
+	funnelCookie = "serve_funnel"
+)
+
+// funnelGate lets in requests from the public internet that carry a share
+// link's token. Funnel connections have no tailnet identity for WhoIs to
+// find, so the token is the only thing standing between the files and
+// anyone who guesses the hostname. Tokens are signed with a key kept in the
+// state directory; deleting it revokes every link.
+type funnelGate struct {
+	key []byte
+	ttl time.Duration // how long new links last; zero for no expiry
+}
+
+// siteFunnel is the gate for public requests, set with -funnel.
+var siteFunnel *funnelGate
+
+// newFunnelGate returns a gate using the key in keyFile, creating the key
+// if there isn't one yet.
+func newFunnelGate(keyFile string, ttl time.Duration) (*funnelGate, error) {
+	if saved, err := os.ReadFile(keyFile); err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(saved)))
+		if err != nil || len(key) < 16 {
+			return nil, fmt.Errorf("%s: bad key; delete it to make a new one", keyFile)
+		}
+		return &funnelGate{key: key, ttl: ttl}, nil
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	if err := os.WriteFile(keyFile, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		return nil, err
+	}
+	return &funnelGate{key: key, ttl: ttl}, nil
+}
+
+// token returns a new share link token, valid until now plus the gate's
+// ttl, and when it expires (zero if it doesn't).
+func (g *funnelGate) token(now time.Time) (string, time.Time) {
+	var expires time.Time
+	var exp int64
+	if g.ttl > 0 {
+		expires = now.Add(g.ttl).Truncate(time.Second)
+		exp = expires.Unix()
+	}
+	s := strconv.FormatInt(exp, 36)
+	return s + "." + g.sign(s), expires
+}
+
+func (g *funnelGate) sign(s string) string {
+	mac := hmac.New(sha256.New, g.key)
+	mac.Write([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:18])
+}
+
+// valid reports whether token is one the gate issued and hasn't expired.
+// Tokens without an expiry are only valid while the gate's ttl is zero.
+func (g *funnelGate) valid(token string, now time.Time) bool {
+	s, sig, _ := strings.Cut(token, ".")
+	if !hmac.Equal([]byte(sig), []byte(g.sign(s))) {
+		return false
+	}
+	expires, ok := tokenExpiry(token)
+	if !ok {
+		return false
+	}
+	if expires.IsZero() {
+		// A link that never expires, minted while -funnel-ttl was 0,
+		// stops working once links are meant to.
+		return g.ttl == 0
+	}
+	return now.Before(expires)
+}
+
+// tokenExpiry returns when token expires, or the zero time if it doesn't.
+func tokenExpiry(token string) (time.Time, bool) {
+	s, _, _ := strings.Cut(token, ".")
+	exp, err := strconv.ParseInt(s, 36, 64)
+	if err != nil || exp < 0 {
+		return time.Time{}, false
+	}
+	if exp == 0 {
+		return time.Time{}, true
+	}
+	return time.Unix(exp, 0), true
+}
+
+// admit reports whether the public request r may go on. A request bearing
+// a valid token in its query is redirected to the same URL without it,
+// with a cookie that admits the visitor's later requests. Requests without
+// a valid token or cookie get a 403.
+func (g *funnelGate) admit(w http.ResponseWriter, r *http.Request) bool {
+	now := time.Now()
+	q := r.URL.Query()
+	if token := q.Get(funnelTokenParam); token != "" {
+		if !g.valid(token, now) {
+			denyAccess(w, r, "invalid or expired link")
+			return false
+		}
+		c := &http.Cookie{
+			Name:     funnelCookie,
+			Value:    token,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		}
+		c.Expires, _ = tokenExpiry(token)
+		http.SetCookie(w, c)
+		// Keep the token out of the address bar, history and Referer.
+		q.Del(funnelTokenParam)
+		u := *r.URL
+		u.RawQuery = q.Encode()
+		http.Redirect(w, r, u.RequestURI(), http.StatusSeeOther)
+		return false
+	}
+	if c, err := r.Cookie(funnelCookie); err == nil && g.valid(c.Value, now) {
+		return true
+	}
+	denyAccess(w, r, "no valid link")
+	return false
+}
+
+// shareLink returns the URL to give people outside the tailnet, and when
+// it stops working.
+func (g *funnelGate) shareLink(serverURL string) (string, time.Time) {
+	token, expires := g.token(time.Now())
+	return serverURL + "/?" + funnelTokenParam + "=" + token, expires
+}
+
+type funnelKey struct{}
+
+// funnelConnContext marks the contexts of requests arriving over Funnel
+// with the address of the client on the public internet, for use as
+// http.Server.ConnContext.
+func funnelConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if fc, ok := c.(*ipn.FunnelConn); ok {
+		return context.WithValue(ctx, funnelKey{}, fc.Src)
+	}
+	return ctx
+}
+
+// funnelSource returns the public address a request came over Funnel
+// from, and whether it did.
+func funnelSource(r *http.Request) (netip.AddrPort, bool) {
+	src, ok := r.Context().Value(funnelKey{}).(netip.AddrPort)
+	return src, ok
+}
+
+// privatePath reports whether the URL path p is in the state directory or
+// another directory serve keeps to itself, which are never served.
+func privatePath(p string) bool {
+	if p == mermaidJSPath {
+		return false
+	}
+	clean := strings.ToLower(path.Clean("/" + p))
+	for seg := range strings.SplitSeq(clean, "/") {
+		if skippedDir(seg) {
+			return true
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return true
+	}
+	state, err := filepath.Abs(*dataDir)
+	if err != nil {
+		return true
+	}
+	rel, err := filepath.Rel(wd, state)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	dir := strings.ToLower("/" + filepath.ToSlash(rel))
+	return dir == "/." || clean == dir || strings.HasPrefix(clean, dir+"/")
+}
+
+// hidePrivate answers a request for a private path with a 404, on every
+// listener: the state directory holds the Funnel key, the node's tailnet
+// state and the access rules. Only the render cache counters are served
+// from there, and not to the public.
+func hidePrivate(w http.ResponseWriter, r *http.Request, urlPath string) bool {
+	if !privatePath(urlPath) {
+		return false
+	}
+	if _, public := funnelSource(r); urlPath == renderCacheStatsPath && !public {
+		return false
+	}
+	http.NotFound(w, r)
+	return true
+}
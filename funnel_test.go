@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn"
+)
+
+func TestFunnelToken(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "funnel-key")
+	g, err := newFunnelGate(keyFile, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	token, expires := g.token(now)
+	if !expires.After(now) || !g.valid(token, now) {
+		t.Fatalf("new token %q (expires %v) not valid", token, expires)
+	}
+	if g.valid(token, now.Add(2*time.Hour)) {
+		t.Error("token valid after it expired")
+	}
+	exp, sig, _ := strings.Cut(token, ".")
+	if g.valid(exp+"x."+sig, now) || g.valid(exp+"."+sig[1:], now) || g.valid("", now) {
+		t.Error("tampered token valid")
+	}
+
+	// The key is kept, so links survive a restart; without a ttl they
+	// don't expire.
+	again, err := newFunnelGate(keyFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.valid(token, now) {
+		t.Error("token invalid after reloading the key")
+	}
+	forever, expires := again.token(now)
+	if !expires.IsZero() || !again.valid(forever, now.Add(10*365*24*time.Hour)) {
+		t.Errorf("token without ttl expires at %v", expires)
+	}
+	// Once links are meant to expire, those that don't stop working.
+	if g.valid(forever, now) {
+		t.Error("token without expiry valid with a ttl")
+	}
+}
+
+func funnelRequest(target string) *http.Request {
+	r := httptest.NewRequest("GET", target, nil)
+	src := netip.MustParseAddrPort("203.0.113.9:41000")
+	return r.WithContext(context.WithValue(r.Context(), funnelKey{}, src))
+}
+
+func TestFunnelAdmit(t *testing.T) {
+	g, err := newFunnelGate(filepath.Join(t.TempDir(), "funnel-key"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, _ := g.shareLink("https://docs.example.ts.net")
+	token := strings.TrimPrefix(link, "https://docs.example.ts.net/?funnel-token=")
+
+	// Following the link sets a cookie and drops the token from the URL.
+	rec := httptest.NewRecorder()
+	if g.admit(rec, funnelRequest("/guide.md?funnel-token="+token+"&raw")) {
+		t.Fatal("request with token admitted without a redirect")
+	}
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/guide.md?raw=" {
+		t.Errorf("redirect = %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != token || !cookies[0].Secure || !cookies[0].HttpOnly {
+		t.Fatalf("cookies = %v", cookies)
+	}
+
+	req := funnelRequest("/guide.md")
+	req.AddCookie(cookies[0])
+	if !g.admit(httptest.NewRecorder(), req) {
+		t.Error("request with cookie refused")
+	}
+
+	for _, req := range []*http.Request{
+		funnelRequest("/guide.md"),
+		funnelRequest("/guide.md?funnel-token=0.bogus"),
+	} {
+		rec := httptest.NewRecorder()
+		if g.admit(rec, req) || rec.Code != http.StatusForbidden {
+			t.Errorf("%s: admitted or code %d, want 403", req.URL, rec.Code)
+		}
+	}
+	if got := requesterName(funnelRequest("/")); got != "public (203.0.113.9)" {
+		t.Errorf("requesterName = %q", got)
+	}
+}
+
+func TestFunnelConnContext(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	src := netip.MustParseAddrPort("198.51.100.7:5000")
+	conn := tls.Server(&ipn.FunnelConn{Conn: c1, Src: src}, &tls.Config{})
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(funnelConnContext(r.Context(), conn))
+	if got, ok := funnelSource(r); !ok || got != src {
+		t.Errorf("funnelSource = %v, %v; want %v", got, ok, src)
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(funnelConnContext(r.Context(), tls.Server(c2, &tls.Config{})))
+	if _, ok := funnelSource(r); ok {
+		t.Error("tailnet connection marked as public")
+	}
+}
+
+func TestPrivatePath(t *testing.T) {
+	t.Chdir(t.TempDir())
+	old := *dataDir
+	t.Cleanup(func() { *dataDir = old })
+
+	*dataDir = "./.serve"
+	for p, want := range map[string]bool{
+		"/.serve/funnel-key":       true,
+		"/.SERVE/tailscaled.state": true,
+		"/docs/.git/config":        true,
+		"/docs/../.serve/acl":      true,
+		mermaidJSPath:              false,
+		"/README.md":               false,
+		"/serve.md":                false,
+	} {
+		if got := privatePath(p); got != want {
+			t.Errorf("privatePath(%q) = %v, want %v", p, got, want)
+		}
+	}
+
+	*dataDir = "state"
+	if !privatePath("/state/funnel-key") || privatePath("/stately.md") {
+		t.Error("custom -dir not kept private")
+	}
+}
+
+func TestHidePrivate(t *testing.T) {
+	t.Chdir(t.TempDir())
+	old := *dataDir
+	t.Cleanup(func() { *dataDir = old })
+	*dataDir = "./.serve"
+
+	tailnet := func(target string) *http.Request {
+		return withIdentity(httptest.NewRequest("GET", target, nil), whoIsUser("bob@example.com", "desktop"))
+	}
+	for _, tc := range []struct {
+		req  *http.Request
+		want bool
+	}{
+		{tailnet("/.serve/funnel-key"), true},
+		{tailnet("/docs/.git/config"), true},
+		{funnelRequest("/.serve/funnel-key"), true},
+		{tailnet(renderCacheStatsPath), false},
+		{funnelRequest(renderCacheStatsPath), true},
+		{tailnet("/README.md"), false},
+	} {
+		rec := httptest.NewRecorder()
+		got := hidePrivate(rec, tc.req, tc.req.URL.Path)
+		if got != tc.want || got && rec.Code != http.StatusNotFound {
+			t.Errorf("hidePrivate(%s) = %v with code %d, want %v", tc.req.URL.Path, got, rec.Code, tc.want)
+		}
+	}
+}
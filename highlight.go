@@ -0,0 +1,43 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"strings"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+)
+
+// Chroma styles used for fenced code blocks, chosen to match the light and
+// dark palettes of markdown.css.
+const (
+	highlightLightStyle = "github"
+	highlightDarkStyle  = "github-dark"
+)
+
+// syntaxHighlighting highlights fenced code blocks with a known language
+// server-side. Tokens get CSS classes rather than inline styles so that one
+// page works in both color schemes; highlightCSS holds the matching rules.
+var syntaxHighlighting = highlighting.NewHighlighting(
+	highlighting.WithFormatOptions(chromahtml.WithClasses(true)),
+)
+
+// highlightCSS is inlined after markdown.css on every rendered page,
+// including standalone exports, so they stay self-contained.
+var highlightCSS = buildHighlightCSS()
+
+func buildHighlightCSS() string {
+	f := chromahtml.New(chromahtml.WithClasses(true))
+	var b strings.Builder
+	f.WriteCSS(&b, styles.Get(highlightLightStyle))
+	b.WriteString("@media (prefers-color-scheme: dark) {\n")
+	f.WriteCSS(&b, styles.Get(highlightDarkStyle))
+	b.WriteString("}\n")
+	// Keep markdown.css's code block background and padding rather than the
+	// theme's, so highlighted and plain blocks look alike.
+	b.WriteString(".markdown-body pre.chroma { background-color: var(--bgColor-muted, #f6f8fa); }\n")
+	return b.String()
+}
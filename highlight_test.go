@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderMarkdownHighlightsFencedCode(t *testing.T) {
+	page, err := renderMarkdown([]byte("```go\nfunc main() {}\n```\n\n```\nplain <text>\n```\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(page.Content)
+	if !strings.Contains(got, `<pre class="chroma">`) || !strings.Contains(got, `<span class="kd">func</span>`) {
+		t.Errorf("go block not highlighted with classes: %s", got)
+	}
+	if strings.Contains(got, "style=") {
+		t.Errorf("highlighting should use classes, not inline styles: %s", got)
+	}
+	if !strings.Contains(got, "<pre><code>plain &lt;text&gt;\n</code></pre>") {
+		t.Errorf("unlabeled block should stay plain: %s", got)
+	}
+}
+
+func TestHighlightCSSCoversBothSchemes(t *testing.T) {
+	light, dark, ok := strings.Cut(highlightCSS, "@media (prefers-color-scheme: dark)")
+	if !ok {
+		t.Fatalf("no dark scheme rules in highlightCSS")
+	}
+	for name, css := range map[string]string{"light": light, "dark": dark} {
+		if !strings.Contains(css, ".chroma .kd {") {
+			t.Errorf("%s rules missing keyword style", name)
+		}
+	}
+}
+
+func TestServeMarkdownDownloadInlinesHighlightCSS(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "code.md", "```go\nx := 1\n```\n", time.Time{})
+
+	rec := httptest.NewRecorder()
+	if !serveMarkdown(rec, httptest.NewRequest("GET", "/code.md?download", nil), "/code.md") {
+		t.Fatal("serveMarkdown returned false")
+	}
+	html := zipBody(t, readZip(t, rec.Body.Bytes())["code.html"])
+	if !strings.Contains(html, ".chroma .kd {") || !strings.Contains(html, `<pre class="chroma">`) {
+		t.Errorf("standalone HTML missing highlighting or its CSS: %s", html)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"net/netip"
+	"unicode/utf8"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// identityHeaders are the headers that tell proxied upstreams who is making
+// a request, as tailscale serve sets them. Clients can't be trusted to
+// send them, so any copies they send are removed.
+var identityHeaders = []string{
+	"Tailscale-User-Login",
+	"Tailscale-User-Name",
+	"Tailscale-User-Profile-Pic",
+	"Tailscale-Node-Name",
+	"Tailscale-Funnel-Request",
+	"Tailscale-Headers-Info",
+}
+
+type identityKey struct{}
+
+// withIdentity returns r carrying the tailnet identity of its sender, if
+// known, for setIdentityHeaders.
+func withIdentity(r *http.Request, who *apitype.WhoIsResponse) *http.Request {
+	if who == nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, who))
+}
+
+// setIdentityHeaders replaces any identity headers in h, a proxied
+// request's headers, with those for the identity in ctx. Headers are only
+// added with -identity-headers, and user headers only for requests from
+// nodes owned by a user rather than tagged. Requests over Funnel are
+// marked as such.
+func setIdentityHeaders(ctx context.Context, h http.Header) {
+	for _, k := range identityHeaders {
+		h.Del(k)
+	}
+	if !*forwardIdentity {
+		return
+	}
+	if _, public := ctx.Value(funnelKey{}).(netip.AddrPort); public {
+		h.Set("Tailscale-Funnel-Request", "?1")
+		return
+	}
+	who, _ := ctx.Value(identityKey{}).(*apitype.WhoIsResponse)
+	if who == nil || who.Node == nil {
+		return
+	}
+	h.Set("Tailscale-Node-Name", headerValue(firstLabel(who.Node.ComputedName)))
+	h.Set("Tailscale-Headers-Info", "https://tailscale.com/s/serve-headers")
+	if who.Node.IsTagged() || who.UserProfile == nil {
+		return
+	}
+	h.Set("Tailscale-User-Login", headerValue(who.UserProfile.LoginName))
+	h.Set("Tailscale-User-Name", headerValue(who.UserProfile.DisplayName))
+	h.Set("Tailscale-User-Profile-Pic", who.UserProfile.ProfilePicURL)
+}
+
+// headerValue makes v safe to send as a header value, Q-encoding it
+// (RFC 2047) if it isn't ASCII. Invalid UTF-8 becomes "".
+func headerValue(v string) string {
+	if !utf8.ValidString(v) {
+		return ""
+	}
+	return mime.QEncoding.Encode("utf-8", v)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+func TestIdentityHeaders(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+	routes, err := newProxyRoutes(nil, upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := &apitype.WhoIsResponse{
+		Node: &tailcfg.Node{ComputedName: "laptop.tail1234.ts.net"},
+		UserProfile: &tailcfg.UserProfile{
+			LoginName:     "alice@example.com",
+			DisplayName:   "Alice Łukasiewicz",
+			ProfilePicURL: "https://example.com/alice.png",
+		},
+	}
+	tagged := &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{ComputedName: "ci", Tags: []string{"tag:ci"}},
+		UserProfile: &tailcfg.UserProfile{LoginName: "tagged-devices"},
+	}
+	proxy := func(who *apitype.WhoIsResponse) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Tailscale-User-Login", "mallory@example.com")
+		req.Header.Set("Tailscale-Funnel-Request", "?1")
+		routes[0].rp.ServeHTTP(httptest.NewRecorder(), withIdentity(req, who))
+	}
+
+	old := *forwardIdentity
+	t.Cleanup(func() { *forwardIdentity = old })
+
+	// Client-supplied headers are dropped even when identity isn't forwarded.
+	*forwardIdentity = false
+	proxy(alice)
+	for _, k := range identityHeaders {
+		if v := got.Get(k); v != "" {
+			t.Errorf("without -identity-headers, %s = %q", k, v)
+		}
+	}
+
+	*forwardIdentity = true
+	proxy(alice)
+	for k, want := range map[string]string{
+		"Tailscale-User-Login":       "alice@example.com",
+		"Tailscale-User-Name":        "=?utf-8?q?Alice_=C5=81ukasiewicz?=",
+		"Tailscale-User-Profile-Pic": "https://example.com/alice.png",
+		"Tailscale-Node-Name":        "laptop",
+		"Tailscale-Funnel-Request":   "",
+	} {
+		if v := got.Get(k); v != want {
+			t.Errorf("%s = %q, want %q", k, v, want)
+		}
+	}
+
+	// Tagged nodes have no user to speak for.
+	proxy(tagged)
+	if v := got.Get("Tailscale-User-Login"); v != "" {
+		t.Errorf("tagged node: Tailscale-User-Login = %q", v)
+	}
+	if v := got.Get("Tailscale-Node-Name"); v != "ci" {
+		t.Errorf("tagged node: Tailscale-Node-Name = %q", v)
+	}
+
+	// Without a known identity (local mode), nothing is added.
+	proxy(nil)
+	if v := got.Get("Tailscale-User-Login"); v != "" {
+		t.Errorf("unknown sender: Tailscale-User-Login = %q", v)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxIncludeDepth bounds how deeply includes may nest, as a backstop to
+// cycle detection.
+const maxIncludeDepth = 16
+
+// includeRegex matches a line that includes another file, either as a
+// comment, <!-- include: part.md -->, or a shortcode, {{< include part.md >}}.
+// The path may be quoted and may end in a line range such as #L10-L40.
+var includeRegex = regexp.MustCompile(`^ {0,3}(?:<!--\s*include:\s*(.+?)\s*-->|\{\{<\s*include\s+(.+?)\s*>\}\})\s*$`)
+
+var lineRangeRegex = regexp.MustCompile(`^L(\d+)(?:-L?(\d+))?$`)
+
+// expandIncludes replaces the include directives in source, the markdown
+// file rel in the tree at root, with the files they name. Markdown files
+// are inlined (without their front matter) and may include others in turn;
+// other files, or markdown given a line range, become fenced code blocks.
+// Include paths are relative to the including file, or to root if they
+// start with "/", and may not leave root. A directive that can't be
+// followed is replaced with a warning. It returns the expanded source and
+// the files it read or tried to, relative to root.
+func expandIncludes(root, rel string, source []byte) ([]byte, []string) {
+	x := &includer{root: root}
+	out := x.expand(source, []string{rel})
+	return out, x.deps
+}
+
+type includer struct {
+	root  string
+	deps  []string
+	lines []int // the line of the outermost file each output line came from
+}
+
+// line returns the line of the outermost file that line n of the expanded
+// source came from.
+func (x *includer) line(n int) int {
+	if n < 1 || n > len(x.lines) {
+		return n
+	}
+	return x.lines[n-1]
+}
+
+// expand expands the includes in source, the last file in stack, which
+// holds the chain of files including it.
+func (x *includer) expand(source []byte, stack []string) []byte {
+	if !bytes.Contains(source, []byte("include")) {
+		return source
+	}
+	var out bytes.Buffer
+	var fence string // the open code fence, if any
+	n := 0           // lines of source read
+	for line := range bytes.Lines(source) {
+		n++
+		trimmed := strings.TrimSpace(string(line))
+		if f := codeFence(trimmed); f != "" {
+			switch {
+			case fence == "":
+				fence = f
+			case strings.HasPrefix(f, fence) && strings.Trim(trimmed, f[:1]) == "":
+				fence = ""
+			}
+		}
+		m := includeRegex.FindSubmatch(bytes.TrimRight(line, "\r\n"))
+		if fence != "" || m == nil {
+			out.Write(line)
+			x.mapLines(stack, n, 1)
+			continue
+		}
+		start := out.Len()
+		target := string(m[1]) + string(m[2])
+		target = strings.Trim(target, `"'`)
+		included, err := x.include(target, stack)
+		if err != nil {
+			included = []byte(fmt.Sprintf("> [!WARNING]\n> Can't include `%s`: %v\n", target, err))
+		}
+		out.Write(included)
+		if len(included) > 0 && included[len(included)-1] != '\n' {
+			out.WriteByte('\n')
+		}
+		// Keep what follows from joining the included block.
+		out.WriteByte('\n')
+		x.mapLines(stack, n, bytes.Count(out.Bytes()[start:], []byte("\n")))
+	}
+	return out.Bytes()
+}
+
+// mapLines records that count lines of output came from line n of the
+// file at the top of stack, if it's the outermost one.
+func (x *includer) mapLines(stack []string, n, count int) {
+	if len(stack) > 1 {
+		return
+	}
+	for range count {
+		x.lines = append(x.lines, n)
+	}
+}
+
+// codeFence returns the run of ` or ~ that opens a fenced code block on
+// line, or "" if it doesn't.
+func codeFence(line string) string {
+	for _, c := range "`~" {
+		n := 0
+		for n < len(line) && rune(line[n]) == c {
+			n++
+		}
+		if n >= 3 {
+			return line[:n]
+		}
+	}
+	return ""
+}
+
+func (x *includer) include(target string, stack []string) ([]byte, error) {
+	p, frag, _ := strings.Cut(target, "#")
+	var rel string
+	if strings.HasPrefix(p, "/") {
+		rel = path.Clean(strings.TrimPrefix(p, "/"))
+	} else {
+		rel = path.Join(path.Dir(stack[len(stack)-1]), p)
+	}
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return nil, fmt.Errorf("outside the served tree")
+	}
+	if slices.Contains(stack, rel) {
+		return nil, fmt.Errorf("it includes itself")
+	}
+	if len(stack) > maxIncludeDepth {
+		return nil, fmt.Errorf("includes nested too deeply")
+	}
+
+	var first, last int
+	if frag != "" {
+		m := lineRangeRegex.FindStringSubmatch(frag)
+		if m == nil {
+			return nil, fmt.Errorf("bad line range #%s; use #L10 or #L10-L40", frag)
+		}
+		first, _ = strconv.Atoi(m[1])
+		last = first
+		if m[2] != "" {
+			last, _ = strconv.Atoi(m[2])
+		}
+		if first < 1 || last < first {
+			return nil, fmt.Errorf("bad line range #%s", frag)
+		}
+	}
+
+	// A file that's missing now is still a dependency: the page changes
+	// when it appears.
+	x.deps = append(x.deps, rel)
+	content, err := os.ReadFile(filepath.Join(x.root, filepath.FromSlash(rel)))
+	if err != nil {
+		return nil, fmt.Errorf("not found")
+	}
+
+	if frag == "" && strings.HasSuffix(strings.ToLower(rel), ".md") {
+		return x.expand(stripFrontMatter(content), append(stack[:len(stack):len(stack)], rel)), nil
+	}
+	if frag != "" {
+		lines := strings.SplitAfter(string(content), "\n")
+		if lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+		if first > len(lines) {
+			return nil, fmt.Errorf("%s has only %d lines", rel, len(lines))
+		}
+		content = []byte(strings.Join(lines[first-1:min(last, len(lines))], ""))
+	}
+	return fencedCode(content, strings.TrimPrefix(path.Ext(rel), ".")), nil
+}
+
+// fencedCode wraps code in a fenced code block, with a fence longer than
+// any run of backticks in it.
+func fencedCode(code []byte, lang string) []byte {
+	longest := 0
+	for run := range strings.SplitSeq(string(code), "\n") {
+		n := 0
+		for _, c := range run {
+			if c == '`' {
+				n++
+				longest = max(longest, n)
+			} else {
+				n = 0
+			}
+		}
+	}
+	fence := strings.Repeat("`", max(3, longest+1))
+	var b bytes.Buffer
+	b.WriteString(fence + lang + "\n")
+	b.Write(code)
+	if len(code) > 0 && code[len(code)-1] != '\n' {
+		b.WriteByte('\n')
+	}
+	b.WriteString(fence + "\n")
+	return b.Bytes()
+}
+
+// stripFrontMatter removes a YAML (---) or TOML (+++) front matter block
+// from the start of source.
+func stripFrontMatter(source []byte) []byte {
+	first, rest, ok := bytes.Cut(source, []byte("\n"))
+	if !ok {
+		return source
+	}
+	delim := strings.TrimRight(string(first), "\r")
+	if delim != "---" && delim != "+++" {
+		return source
+	}
+	end := len(first) + 1
+	for line := range bytes.Lines(rest) {
+		end += len(line)
+		if strings.TrimSpace(string(line)) == delim {
+			return source[end:]
+		}
+	}
+	return source
+}
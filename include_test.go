@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandIncludes(t *testing.T) {
+	dir := t.TempDir()
+	page := "# Page\n\n<!-- include: parts/intro.md -->\n{{< include \"/code/main.go#L2-L3\" >}}\n\n```\n<!-- include: parts/intro.md -->\n```\n"
+	writeFile(t, dir, "docs/page.md", page, time.Time{})
+	writeFile(t, dir, "docs/parts/intro.md", "---\ntitle: Intro\n---\n## Setup\nIntro text.\n<!-- include: ../loop.md -->\n", time.Time{})
+	writeFile(t, dir, "docs/loop.md", "<!-- include: page.md -->\n", time.Time{})
+	writeFile(t, dir, "code/main.go", "package main\n\nfunc main() {}\n// ```\n", time.Time{})
+
+	out, deps := expandIncludes(dir, "docs/page.md", []byte(page))
+	got := string(out)
+	for _, want := range []string{
+		"## Setup\nIntro text.\n",
+		"> [!WARNING]\n> Can't include `page.md`: it includes itself\n",
+		"```go\n\nfunc main() {}\n```\n",
+		"```\n<!-- include: parts/intro.md -->\n```\n", // inside a code block
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expansion missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "title: Intro") {
+		t.Errorf("included front matter kept:\n%s", got)
+	}
+	if strings.Join(deps, " ") != "docs/parts/intro.md docs/loop.md code/main.go" {
+		t.Errorf("deps = %v", deps)
+	}
+
+	for target, want := range map[string]string{
+		"../../etc/passwd":   "outside the served tree",
+		"nope.md":            "not found",
+		"/code/main.go#L9":   "has only 4 lines",
+		"/code/main.go#L3-1": "bad line range",
+		"/code/main.go#x":    "bad line range",
+	} {
+		out, _ := expandIncludes(dir, "docs/page.md", []byte("<!-- include: "+target+" -->\n"))
+		if !strings.Contains(string(out), want) {
+			t.Errorf("include %s = %q, want error %q", target, out, want)
+		}
+	}
+
+	// Backticks in included code don't close the fence early.
+	out, _ = expandIncludes(dir, "x.md", []byte("{{< include code/main.go >}}\n"))
+	if !strings.HasPrefix(string(out), "````go\npackage main\n") {
+		t.Errorf("code fence not lengthened: %q", out)
+	}
+}
+
+func TestServeMarkdownIncludes(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "a.md", "# Usage\n\n<!-- include: b.md -->\n", time.Time{})
+	writeFile(t, dir, "b.md", "# Usage\n\nShared part.\n", time.Time{})
+
+	rec := httptest.NewRecorder()
+	serveMarkdown(rec, httptest.NewRequest("GET", "/a.md", nil), "/a.md")
+	body := rec.Body.String()
+	if !strings.Contains(body, "<p>Shared part.</p>") {
+		t.Errorf("include not rendered: %s", body)
+	}
+	// Heading IDs stay unique across the included content.
+	if !strings.Contains(body, `<h1 id="usage">`) || !strings.Contains(body, `<h1 id="usage-1">`) {
+		t.Errorf("heading IDs not de-duplicated: %s", body)
+	}
+}
@@ -0,0 +1,398 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// brokenLink is a link or image in a markdown file that doesn't resolve.
+type brokenLink struct {
+	File   string `json:"file"` // slash-separated, relative to the root
+	Line   int    `json:"line"`
+	Target string `json:"target"`
+	Reason string `json:"reason"`
+}
+
+// linkReport is the result of checking a tree's links.
+type linkReport struct {
+	Files  int          `json:"files"`
+	Links  int          `json:"links"`
+	Broken []brokenLink `json:"broken"`
+}
+
+// linkChecker checks the links of markdown files under root, which is the
+// directory absolute links such as "/docs/a.md" are relative to.
+type linkChecker struct {
+	root    string
+	pages   []string                   // markdown files, for [[links]]
+	anchors map[string]map[string]bool // IDs in each markdown file, by path
+}
+
+// checkLinks checks every markdown file under the directory under (relative
+// to root, "" for all of it). Links to other sites aren't fetched; relative
+// links must name an existing file or directory inside root, and #fragments
+// must name a heading, or an HTML id, in the markdown they point at.
+// [[Wiki links]] must name a page, and if visible isn't nil, one it reports
+// true for. Pages are checked as they're rendered, with their includes in
+// place, and links from an included file are reported at the line
+// including it.
+func checkLinks(root, under string, visible func(rel string) bool) (*linkReport, error) {
+	pages, err := markdownPages(root)
+	if err != nil {
+		return nil, err
+	}
+	if visible != nil {
+		pages = slices.DeleteFunc(pages, func(p string) bool { return !visible(p) })
+	}
+	c := &linkChecker{root: root, pages: pages, anchors: make(map[string]map[string]bool)}
+	report := &linkReport{Broken: []brokenLink{}}
+	start := filepath.Join(root, filepath.FromSlash(under))
+	err = filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != start && skippedDir(d.Name()) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(strings.ToLower(d.Name()), ".md") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		report.Files++
+		return c.checkFile(filepath.ToSlash(rel), report)
+	})
+	return report, err
+}
+
+// readPage returns the markdown file rel with its includes expanded, as
+// it's rendered, and the includer that expanded it.
+func (c *linkChecker) readPage(rel string) ([]byte, *includer, error) {
+	src, err := os.ReadFile(filepath.Join(c.root, filepath.FromSlash(rel)))
+	if err != nil {
+		return nil, nil, err
+	}
+	x := &includer{root: c.root}
+	return x.expand(src, []string{rel}), x, nil
+}
+
+func (c *linkChecker) checkFile(rel string, report *linkReport) error {
+	src, x, err := c.readPage(rel)
+	if err != nil {
+		return err
+	}
+	pc := parser.NewContext()
+	pc.Set(wikiPageKey, &wikiPage{rel: rel, pages: c.pages})
+	doc := md.Parser().Parse(text.NewReader(src), parser.WithContext(pc))
+	c.anchors[rel] = collectAnchors(doc, src)
+
+	return ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		var dest, reason string
+		switch n := n.(type) {
+		case *ast.Link:
+			dest = string(n.Destination)
+			reason = c.check(rel, dest)
+		case *ast.Image:
+			dest = string(n.Destination)
+			reason = c.check(rel, dest)
+		case *wikiLink:
+			dest = n.String()
+			if n.Dest == "" {
+				reason = "no page named " + n.Page
+			} else {
+				reason = c.check(rel, n.Dest)
+			}
+		default:
+			return ast.WalkContinue, nil
+		}
+		report.Links++
+		if reason != "" {
+			report.Broken = append(report.Broken, brokenLink{
+				File:   rel,
+				Line:   x.line(nodeLine(n, src)),
+				Target: dest,
+				Reason: reason,
+			})
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// check returns why dest, linked from the markdown file rel, is broken, or
+// "" if it isn't.
+func (c *linkChecker) check(rel, dest string) string {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return "malformed URL"
+	}
+	if u.Scheme != "" || u.Host != "" {
+		return "" // another site
+	}
+	if u.Path == "" {
+		if u.Fragment != "" && !c.anchors[rel][u.Fragment] {
+			return "no anchor #" + u.Fragment
+		}
+		return ""
+	}
+
+	var target string
+	if strings.HasPrefix(u.Path, "/") {
+		target = path.Clean(strings.TrimPrefix(u.Path, "/"))
+	} else {
+		target = path.Join(path.Dir(rel), u.Path)
+	}
+	if target == ".." || strings.HasPrefix(target, "../") {
+		return "outside the served tree"
+	}
+	info, err := os.Stat(filepath.Join(c.root, filepath.FromSlash(target)))
+	if err != nil {
+		return "not found"
+	}
+	if u.Fragment == "" {
+		return ""
+	}
+	if info.IsDir() {
+		// A directory shows its index file, if it has one.
+		if *index == "" {
+			return ""
+		}
+		target = path.Join(target, *index)
+		if _, err := os.Stat(filepath.Join(c.root, filepath.FromSlash(target))); err != nil {
+			return ""
+		}
+	}
+	if !strings.HasSuffix(strings.ToLower(target), ".md") {
+		return "" // fragments of other files, like #L10 or #page=2, aren't checked
+	}
+	ids, err := c.fileAnchors(target)
+	if err != nil {
+		return err.Error()
+	}
+	if !ids[u.Fragment] {
+		return "no anchor #" + u.Fragment
+	}
+	return ""
+}
+
+func (c *linkChecker) fileAnchors(rel string) (map[string]bool, error) {
+	if ids, ok := c.anchors[rel]; ok {
+		return ids, nil
+	}
+	src, _, err := c.readPage(rel)
+	if err != nil {
+		return nil, err
+	}
+	ids := collectAnchors(md.Parser().Parse(text.NewReader(src)), src)
+	c.anchors[rel] = ids
+	return ids, nil
+}
+
+// htmlAnchorRegex finds the ids and names of elements in raw HTML, which
+// can be linked to just like headings.
+var htmlAnchorRegex = regexp.MustCompile(`\s(?:id|name)\s*=\s*["']([^"']+)["']`)
+
+// collectAnchors returns the IDs a parsed page can be linked to with a
+// #fragment: its headings' githubSlug IDs and any ids in raw HTML.
+func collectAnchors(doc ast.Node, src []byte) map[string]bool {
+	ids := make(map[string]bool)
+	addHTML := func(b []byte) {
+		for _, m := range htmlAnchorRegex.FindAllSubmatch(b, -1) {
+			ids[string(m[1])] = true
+		}
+	}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		if id, ok := n.AttributeString("id"); ok {
+			if b, ok := id.([]byte); ok {
+				ids[string(b)] = true
+			}
+		}
+		switch n := n.(type) {
+		case *ast.HTMLBlock:
+			for i := 0; i < n.Lines().Len(); i++ {
+				line := n.Lines().At(i)
+				addHTML(line.Value(src))
+			}
+		case *ast.RawHTML:
+			for i := 0; i < n.Segments.Len(); i++ {
+				seg := n.Segments.At(i)
+				addHTML(seg.Value(src))
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return ids
+}
+
+// nodeLine returns the line of src that inline node n starts on: that of
+// its first text, or else of the block holding it.
+func nodeLine(n ast.Node, src []byte) int {
+	offset := -1
+	ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if t, ok := c.(*ast.Text); ok && entering {
+			offset = t.Segment.Start
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	for p := n; offset < 0 && p != nil; p = p.Parent() {
+		if p.Type() == ast.TypeBlock && p.Lines().Len() > 0 {
+			offset = p.Lines().At(0).Start
+		}
+	}
+	if offset < 0 {
+		return 0
+	}
+	return bytes.Count(src[:offset], []byte("\n")) + 1
+}
+
+// serveLinkCheck serves ?linkcheck on a directory: a report of the broken
+// links in the markdown under it, as HTML or JSON.
+func serveLinkCheck(w http.ResponseWriter, r *http.Request, urlPath string) bool {
+	if !strings.HasSuffix(urlPath, "/") {
+		return false
+	}
+	under := filepath.Clean(strings.TrimPrefix(urlPath, "/"))
+	if strings.HasPrefix(under, "..") {
+		return false
+	}
+	if under == "." {
+		under = ""
+	}
+
+	report, err := checkLinks(".", filepath.ToSlash(under), func(rel string) bool { return accessAllowed(r, "/"+rel) })
+	if err != nil {
+		http.Error(w, "link check failed: "+err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	report.Broken = slices.DeleteFunc(report.Broken, func(b brokenLink) bool { return !accessAllowed(r, "/"+b.File) })
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+		return true
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	linkCheckTemplate.Execute(w, struct {
+		Title     string
+		BaseCSS   template.CSS
+		CustomCSS template.CSS
+		Report    *linkReport
+	}{
+		Title:     "Link check",
+		BaseCSS:   template.CSS(markdownCSS),
+		CustomCSS: template.CSS(customCSS),
+		Report:    report,
+	})
+	return true
+}
+
+var linkCheckTemplate = template.Must(template.New("linkcheck").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+{{.BaseCSS}}
+.markdown-body {
+	box-sizing: border-box;
+	min-width: 200px;
+	max-width: 980px;
+	margin: 0 auto;
+	padding: 45px;
+}
+@media (max-width: 767px) {
+	.markdown-body { padding: 15px; }
+}
+.controls {
+	float: right;
+	font-size: 14px;
+}
+.controls a {
+	color: var(--fgColor-muted, #656d76);
+	margin-left: 16px;
+}
+.markdown-body table.broken {
+	display: table;
+	width: 100%;
+}
+table.broken code {
+	word-break: break-all;
+}
+{{.CustomCSS}}
+</style>
+</head>
+<body class="markdown-body">
+<div class="controls"><a href="/">Browse</a></div>
+<h1>{{.Title}}</h1>
+{{with .Report}}<p>Checked {{.Links}} links in {{.Files}} markdown files: {{with .Broken}}{{len .}} broken.{{else}}none broken.{{end}}</p>
+{{with .Broken}}<table class="broken">
+<thead><tr><th>File</th><th>Link</th><th>Problem</th></tr></thead>
+<tbody>
+{{range .}}<tr><td><a href="/{{.File}}">{{.File}}</a>:{{.Line}}</td><td><code>{{.Target}}</code></td><td>{{.Reason}}</td></tr>
+{{end}}</tbody>
+</table>
+{{end}}{{end}}</body>
+</html>
+`))
+
+// runCheck implements "serve check [dir]", printing the broken links in the
+// markdown under dir (by default the current directory) one per line. It
+// returns the process exit code: 1 if any links are broken.
+func runCheck(args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: serve check [dir]")
+		return 2
+	}
+	root := "."
+	if len(args) == 1 {
+		root = args[0]
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "serve check: %s is not a directory\n", root)
+		return 2
+	}
+	report, err := checkLinks(root, "", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "serve check: %v\n", err)
+		return 2
+	}
+	for _, b := range report.Broken {
+		fmt.Printf("%s:%d: %s: %s\n", filepath.Join(root, filepath.FromSlash(b.File)), b.Line, b.Target, b.Reason)
+	}
+	fmt.Fprintf(os.Stderr, "checked %d links in %d files, %d broken\n", report.Links, report.Files, len(report.Broken))
+	if len(report.Broken) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckLinks(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "README.md", "---\ntitle: Home\n---\n# Home\n\n## Getting Started\n\n"+
+		"[ok](docs/guide.md#setup) [self](#getting-started) [dir](docs/) [abs](/docs/guide.md)\n"+
+		"[web](https://example.com/missing.md) [mail](mailto:a@b.c) ![logo](img/logo.png)\n"+
+		"[missing](nope.md)\n"+
+		"[bad anchor](docs/guide.md#nowhere)\n"+
+		"![gone](img/gone.png) [escape](../outside.md) [html](docs/guide.md#custom)\n", time.Time{})
+	writeFile(t, dir, "docs/guide.md", "# Guide\n\n## Setup\n\n<a id=\"custom\"></a>\n\n[back](../README.md#home) [up](../#getting-started)\n", time.Time{})
+	writeFile(t, dir, "img/logo.png", "PNG", time.Time{})
+	writeFile(t, dir, ".git/x.md", "[broken](nope.md)", time.Time{})
+
+	report, err := checkLinks(".", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Files != 2 || report.Links != 14 {
+		t.Errorf("checked %d links in %d files, want 14 in 2", report.Links, report.Files)
+	}
+	var got []string
+	for _, b := range report.Broken {
+		got = append(got, b.Target+" "+b.Reason)
+	}
+	want := []string{
+		"nope.md not found",
+		"docs/guide.md#nowhere no anchor #nowhere",
+		"img/gone.png not found",
+		"../outside.md outside the served tree",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("broken =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if len(report.Broken) > 0 && (report.Broken[0].File != "README.md" || report.Broken[0].Line != 10) {
+		t.Errorf("first broken link at %s:%d, want README.md:10", report.Broken[0].File, report.Broken[0].Line)
+	}
+
+	// Pages are checked with their includes in place.
+	writeFile(t, dir, "parts/intro.md", "## Included\n\n[sibling](other.md) [rooted](/parts/other.md)\n", time.Time{})
+	writeFile(t, dir, "parts/other.md", "# Other\n", time.Time{})
+	writeFile(t, dir, "docs/inc.md", "# Inc\n\n<!-- include: ../parts/intro.md -->\n\n[anchor](#included)\n", time.Time{})
+	report, err = checkLinks(".", "docs", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Broken) != 1 || report.Broken[0].File != "docs/inc.md" || report.Broken[0].Line != 3 || report.Broken[0].Target != "other.md" {
+		t.Errorf("docs report with includes = %+v", report)
+	}
+	os.Remove(filepath.Join(dir, "docs/inc.md"))
+
+	// Checking a subdirectory still resolves links against the whole tree.
+	if report, _ := checkLinks(".", "docs", nil); report.Files != 1 || len(report.Broken) != 0 {
+		t.Errorf("docs report = %+v", report)
+	}
+}
+
+func TestServeLinkCheck(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "a.md", "[x](<b c.md>)\n", time.Time{})
+
+	rec := httptest.NewRecorder()
+	if !serveLinkCheck(rec, httptest.NewRequest("GET", "/?linkcheck", nil), "/") {
+		t.Fatal("serveLinkCheck returned false")
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `<a href="/a.md">a.md</a>:1`) || !strings.Contains(body, "<code>b c.md</code>") {
+		t.Errorf("report missing broken link: %s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	serveLinkCheck(rec, httptest.NewRequest("GET", "/?linkcheck&format=json", nil), "/")
+	var report linkReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Files != 1 || len(report.Broken) != 1 || report.Broken[0].Reason != "not found" {
+		t.Errorf("JSON report = %+v", report)
+	}
+
+	writeFile(t, dir, "b c.md", "# B\n", time.Time{})
+	if code := runCheck([]string{dir}); code != 0 {
+		t.Errorf("runCheck = %d after fixing the link", code)
+	}
+	writeFile(t, dir, "d.md", "[gone](gone.md)\n", time.Time{})
+	if code := runCheck(nil); code != 1 {
+		t.Errorf("runCheck = %d with a broken link, want 1", code)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// localListener is the listener for local mode. Its requests come from this
+// machine (or, in local mode alone, the LAN) rather than the tailnet, so they
+// carry no Tailscale identity and aren't subject to .serve/acl.
+type localListener struct{ net.Listener }
+
+type localKey struct{}
+
+// listenerContext returns an http.Server.BaseContext deriving request
+// contexts from ctx, with those on a localListener marked as local.
+func listenerContext(ctx context.Context) func(net.Listener) context.Context {
+	local := context.WithValue(ctx, localKey{}, true)
+	return func(ln net.Listener) context.Context {
+		if _, ok := ln.(localListener); ok {
+			return local
+		}
+		return ctx
+	}
+}
+
+// localRequest reports whether r arrived on the local listener.
+func localRequest(r *http.Request) bool {
+	local, _ := r.Context().Value(localKey{}).(bool)
+	return local
+}
+
+// serveAll serves srv on each of lns at once. It returns nil once srv has
+// been shut down, or the first error from any listener.
+func serveAll(srv *http.Server, lns []net.Listener) error {
+	errc := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func() { errc <- srv.Serve(ln) }()
+	}
+	for range lns {
+		if err := <-errc; err != http.ErrServerClosed {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeAll(t *testing.T) {
+	useACL(t, "deny /** *\n")
+	listen := func() net.Listener {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ln
+	}
+	localLn, tailnetLn := listen(), listen()
+	srv := &http.Server{
+		BaseContext: listenerContext(context.Background()),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !accessAllowed(r, r.URL.Path) {
+				http.Error(w, "denied", http.StatusForbidden)
+				return
+			}
+			io.WriteString(w, "ok")
+		}),
+	}
+	done := make(chan error)
+	go func() { done <- serveAll(srv, []net.Listener{localListener{localLn}, tailnetLn}) }()
+
+	// The same handler answers on both, but the access rules only apply
+	// away from the local listener.
+	for ln, want := range map[net.Listener]int{localLn: http.StatusOK, tailnetLn: http.StatusForbidden} {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/notes.md")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: status %d, want %d", ln.Addr(), resp.StatusCode, want)
+		}
+	}
+
+	srv.Shutdown(context.Background())
+	if err := <-done; err != nil {
+		t.Errorf("serveAll after shutdown: %v", err)
+	}
+}
+
+func TestLocalRequest(t *testing.T) {
+	base := listenerContext(context.Background())
+	r := httptest.NewRequest("GET", "/", nil)
+	if localRequest(r.WithContext(base(nil))) {
+		t.Error("request on another listener marked local")
+	}
+	if !localRequest(r.WithContext(base(localListener{}))) {
+		t.Error("request on the local listener not marked local")
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watcher fans out file system change notifications to live-reload
+// subscribers. It is backed by fsnotify (inotify on Linux, kqueue on macOS)
+// and only watches directories that currently have subscribers.
+type watcher struct {
+	fsw *fsnotify.Watcher
+
+	mu   sync.Mutex
+	subs map[string]map[*subscription]struct{} // keyed by cleaned directory
+}
+
+// subscription receives a value on ch whenever its directory changes, or
+// only when the named entry changes if name is non-empty. The channel has a
+// one-element buffer so bursts of events from a single save coalesce.
+type subscription struct {
+	name string
+	ch   chan struct{}
+}
+
+var (
+	liveOnce sync.Once
+	live     *watcher
+	liveErr  error
+)
+
+// liveWatcher returns the process-wide watcher, starting it on first use.
+func liveWatcher() (*watcher, error) {
+	liveOnce.Do(func() {
+		live, liveErr = newWatcher()
+	})
+	return live, liveErr
+}
+
+func newWatcher() (*watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &watcher{
+		fsw:  fsw,
+		subs: make(map[string]map[*subscription]struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *watcher) run() {
+	for {
+		select {
+		case ev, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			if ev.Op == fsnotify.Chmod {
+				continue // Spotlight, backup tools, etc. touch metadata constantly
+			}
+			name := filepath.Clean(ev.Name)
+			w.notify(filepath.Dir(name), filepath.Base(name))
+			w.notify(name, "") // the watched directory itself changed
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("watch error: %v", err)
+		}
+	}
+}
+
+func (w *watcher) notify(dir, name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for s := range w.subs[dir] {
+		if s.name != "" && s.name != name {
+			continue
+		}
+		select {
+		case s.ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// subscribe watches dir (or just the entry called name within it) and
+// returns a channel that fires on changes, plus a func to release it.
+func (w *watcher) subscribe(dir, name string) (<-chan struct{}, func(), error) {
+	dir = filepath.Clean(dir)
+	s := &subscription{name: name, ch: make(chan struct{}, 1)}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.subs[dir] == nil {
+		if err := w.fsw.Add(dir); err != nil {
+			return nil, nil, err
+		}
+		w.subs[dir] = make(map[*subscription]struct{})
+	}
+	w.subs[dir][s] = struct{}{}
+
+	cancel := func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.subs[dir], s)
+		if len(w.subs[dir]) == 0 {
+			delete(w.subs, dir)
+			w.fsw.Remove(dir)
+		}
+	}
+	return s.ch, cancel, nil
+}
+
+// liveReloadPing is how often an idle event stream sends a comment line, so
+// intermediaries don't time out the connection.
+const liveReloadPing = 30 * time.Second
+
+// serveLiveReload streams Server-Sent Events for the file or directory at
+// urlPath, emitting a "change" event whenever it is modified. Pages rendered
+// by serveMarkdown and serveDirList subscribe to it via ?livereload.
+func serveLiveReload(w http.ResponseWriter, r *http.Request, urlPath string) bool {
+	clean := filepath.Clean(strings.TrimPrefix(urlPath, "/"))
+	if strings.HasPrefix(clean, "..") {
+		return false
+	}
+	info, err := os.Stat(clean)
+	if err != nil {
+		return false
+	}
+	dir, name := clean, ""
+	if !info.IsDir() {
+		dir, name = filepath.Dir(clean), filepath.Base(clean)
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return true
+	}
+	lw, err := liveWatcher()
+	if err != nil {
+		http.Error(w, "live reload unavailable", http.StatusServiceUnavailable)
+		return true
+	}
+	changes, cancel, err := lw.subscribe(dir, name)
+	if err != nil {
+		http.Error(w, "live reload unavailable", http.StatusServiceUnavailable)
+		return true
+	}
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": watching\n\n")
+	flusher.Flush()
+
+	ping := time.NewTicker(liveReloadPing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return true
+		case <-changes:
+			fmt.Fprint(w, "event: change\ndata: \n\n")
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+// liveReloadURL returns the event stream URL for the page at urlPath.
+func liveReloadURL(urlPath string) string {
+	return (&url.URL{Path: urlPath, RawQuery: "livereload"}).String()
+}
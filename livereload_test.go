@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatcherNotifiesNamedFileOnly(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "a.md", "a", time.Time{})
+	writeFile(t, dir, "b.md", "b", time.Time{})
+
+	w, err := newWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, cancel, err := w.subscribe(dir, "a.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	writeFile(t, dir, "b.md", "b2", time.Time{})
+	select {
+	case <-changes:
+		t.Fatal("subscription for a.md fired on a write to b.md")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	writeFile(t, dir, "a.md", "a2", time.Time{})
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification for a write to a.md")
+	}
+}
+
+func TestServeLiveReloadStreamsChange(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "doc.md", "# One\n", time.Time{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !serveLiveReload(w, r, r.URL.Path) {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/doc.md?livereload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	lines := make(chan string, 64)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+		close(lines)
+	}()
+	if first := <-lines; !strings.HasPrefix(first, ":") {
+		t.Fatalf("first line = %q, want a comment", first)
+	}
+
+	writeFile(t, dir, "doc.md", "# Two\n", time.Time{})
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("stream closed before change event")
+			}
+			if line == "event: change" {
+				return
+			}
+		case <-timeout:
+			t.Fatal("no change event after editing doc.md")
+		}
+	}
+}
+
+func TestServeMarkdownSubscribesToLiveReload(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "my doc.md", "# Doc\n", time.Time{})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/my%20doc.md", nil)
+	if !serveMarkdown(rec, req, "/my doc.md") {
+		t.Fatal("serveMarkdown returned false")
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `new EventSource("/my%20doc.md?livereload")`) {
+		t.Errorf("page does not subscribe to its live reload stream: %s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	if !serveDirList(rec, httptest.NewRequest("GET", "/", nil), "/") {
+		t.Fatal("serveDirList returned false")
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `new EventSource("/?livereload")`) {
+		t.Errorf("listing does not subscribe to its live reload stream: %s", body)
+	}
+}
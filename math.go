@@ -0,0 +1,238 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"html"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// mathExtension renders LaTeX math the way GitHub recognizes it: $...$
+// inline, $$...$$ for display math (inline or as a block), and ```math
+// fenced blocks. Expressions are converted to MathML on the server, so pages
+// and exports need no scripts or network access to show them.
+type mathExtension struct{}
+
+func (mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(mathBlockParser{}, 90)),
+		parser.WithInlineParsers(util.Prioritized(mathInlineParser{}, 150)),
+		parser.WithASTTransformers(util.Prioritized(mathFenceTransformer{}, 50)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(mathRenderer{}, 100),
+	))
+}
+
+var (
+	kindMathInline = ast.NewNodeKind("MathInline")
+	kindMathBlock  = ast.NewNodeKind("MathBlock")
+)
+
+type mathInline struct {
+	ast.BaseInline
+	Value   []byte
+	Display bool // written as $$...$$
+}
+
+func (n *mathInline) Kind() ast.NodeKind { return kindMathInline }
+
+func (n *mathInline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Value": string(n.Value)}, nil)
+}
+
+type mathBlock struct {
+	ast.BaseBlock
+	closed bool // the closing $$ has been seen
+}
+
+func (n *mathBlock) Kind() ast.NodeKind { return kindMathBlock }
+func (n *mathBlock) IsRaw() bool        { return true }
+
+func (n *mathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+type mathInlineParser struct{}
+
+func (mathInlineParser) Trigger() []byte { return []byte{'$'} }
+
+func (mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	opener := 0
+	for opener < len(line) && line[opener] == '$' {
+		opener++
+	}
+	if opener > 2 {
+		return nil
+	}
+	// Like GitHub, "$ 5" is not math: the content can't start with a space.
+	if opener == 1 && (len(line) < 2 || util.IsSpace(line[1])) {
+		return nil
+	}
+
+	l, pos := block.Position()
+	block.Advance(opener)
+	var value []byte
+	for {
+		line, _ := block.PeekLine()
+		if line == nil {
+			block.SetPosition(l, pos)
+			return nil
+		}
+		for i := 0; i < len(line); i++ {
+			switch line[i] {
+			case '\\':
+				i++ // \$ doesn't close
+			case '$':
+				n := 1
+				for i+n < len(line) && line[i+n] == '$' {
+					n++
+				}
+				if n == opener && validMathClose(line, i, n, len(value)+i) {
+					value = append(value, line[:i]...)
+					block.Advance(i + n)
+					return &mathInline{Value: value, Display: opener == 2}
+				}
+				i += n - 1
+			}
+		}
+		value = append(value, line...)
+		block.AdvanceLine()
+	}
+}
+
+// validMathClose reports whether the n dollar signs at line[i] can close
+// inline math with size bytes of content. A single $ can't follow a space
+// or precede a digit, so prices like "$5 and $10" stay text.
+func validMathClose(line []byte, i, n, size int) bool {
+	if size == 0 {
+		return false
+	}
+	if n == 2 {
+		return true
+	}
+	if i > 0 && util.IsSpace(line[i-1]) {
+		return false
+	}
+	return i+1 >= len(line) || line[i+1] < '0' || line[i+1] > '9'
+}
+
+type mathBlockParser struct{}
+
+func (mathBlockParser) Trigger() []byte { return []byte{'$'} }
+
+func (mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte("$$")) {
+		return nil, parser.NoChildren
+	}
+	node := &mathBlock{}
+	start := segment.Start + pos + 2
+	rest := line[pos+2:]
+	if i := bytes.Index(rest, []byte("$$")); i >= 0 {
+		// $$ ... $$ on one line is a block only if nothing follows it;
+		// otherwise it's inline display math inside a paragraph.
+		if !util.IsBlank(rest[i+2:]) {
+			return nil, parser.NoChildren
+		}
+		node.Lines().Append(text.NewSegment(start, start+i))
+		node.closed = true
+	} else if !util.IsBlank(rest) {
+		node.Lines().Append(text.NewSegment(start, segment.Stop))
+	}
+	reader.AdvanceToEOL()
+	return node, parser.NoChildren
+}
+
+func (mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	n := node.(*mathBlock)
+	if n.closed {
+		return parser.Close
+	}
+	line, segment := reader.PeekLine()
+	if i := bytes.Index(line, []byte("$$")); i >= 0 && util.IsBlank(line[i+2:]) {
+		if !util.IsBlank(line[:i]) {
+			n.Lines().Append(text.NewSegment(segment.Start, segment.Start+i))
+		}
+		reader.AdvanceToEOL()
+		return parser.Close
+	}
+	n.Lines().Append(segment)
+	reader.AdvanceToEOL()
+	return parser.Continue | parser.NoChildren
+}
+
+func (mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+func (mathBlockParser) CanInterruptParagraph() bool                                { return true }
+func (mathBlockParser) CanAcceptIndentedLine() bool                                { return false }
+
+// mathFenceTransformer turns ```math fenced code blocks into math blocks.
+type mathFenceTransformer struct{}
+
+func (mathFenceTransformer) Transform(doc *ast.Document, reader text.Reader, _ parser.Context) {
+	source := reader.Source()
+	var fences []*ast.FencedCodeBlock
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if f, ok := n.(*ast.FencedCodeBlock); ok && entering && string(f.Language(source)) == "math" {
+			fences = append(fences, f)
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, f := range fences {
+		m := &mathBlock{closed: true}
+		m.SetLines(f.Lines())
+		f.Parent().ReplaceChild(f.Parent(), f, m)
+	}
+}
+
+type mathRenderer struct{}
+
+func (mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMathInline, func(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			m := n.(*mathInline)
+			writeMath(w, string(m.Value), m.Display)
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	reg.Register(kindMathBlock, func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			var tex []byte
+			lines := n.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				seg := lines.At(i)
+				tex = append(tex, seg.Value(source)...)
+			}
+			writeMath(w, string(tex), true)
+			w.WriteByte('\n')
+		}
+		return ast.WalkSkipChildren, nil
+	})
+}
+
+// writeMath writes tex as MathML, or as the original source marked with
+// the error if it can't be converted.
+func writeMath(w util.BufWriter, tex string, display bool) {
+	out, err := texToMathML(tex, display)
+	if err != nil {
+		delim := "$"
+		if display {
+			delim = "$$"
+		}
+		w.WriteString(`<code class="math-error" title="` + html.EscapeString(err.Error()) + `">`)
+		w.WriteString(html.EscapeString(delim + tex + delim))
+		w.WriteString("</code>")
+		return
+	}
+	w.WriteString(out)
+}
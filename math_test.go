@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdownMath(t *testing.T) {
+	cases := []struct {
+		name, src string
+		want      []string
+		dontWant  []string
+	}{
+		{
+			name: "inline",
+			src:  "Area is $\\pi r^2$ here.\n",
+			want: []string{`<p>Area is <math xmlns="http://www.w3.org/1998/Math/MathML"><semantics>`, `<mi>π</mi>`, ` here.</p>`},
+		},
+		{
+			name:     "currency is not math",
+			src:      "It costs $5 or $10, and \\$x\\$ is escaped.\n",
+			want:     []string{"It costs $5 or $10, and $x$ is escaped."},
+			dontWant: []string{"<math"},
+		},
+		{
+			name:     "code spans are untouched",
+			src:      "Use `$x$` literally.\n",
+			want:     []string{"<code>$x$</code>"},
+			dontWant: []string{"<math"},
+		},
+		{
+			name: "display block",
+			src:  "$$\n\\frac{a}{b}\n$$\n",
+			want: []string{`<math xmlns="http://www.w3.org/1998/Math/MathML" display="block">`, `<mfrac><mi>a</mi><mi>b</mi></mfrac>`},
+		},
+		{
+			name: "math fence",
+			src:  "```math\nE = mc^2\n```\n",
+			want: []string{`display="block"`, `<msup><mi>c</mi><mn>2</mn></msup>`},
+		},
+		{
+			name:     "emphasis markers inside math",
+			src:      "$a_1 * b_2 * c$\n",
+			want:     []string{`<msub><mi>a</mi><mn>1</mn></msub>`},
+			dontWant: []string{"<em>"},
+		},
+		{
+			name: "error falls back to source",
+			src:  "Broken $\\frac{a$ math.\n",
+			want: []string{`<code class="math-error" title="unbalanced braces">$\frac{a$</code>`},
+		},
+	}
+	for _, c := range cases {
+		page, err := renderMarkdown([]byte(c.src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(page.Content)
+		for _, w := range c.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%s: missing %q in:\n%s", c.name, w, got)
+			}
+		}
+		for _, w := range c.dontWant {
+			if strings.Contains(got, w) {
+				t.Errorf("%s: unexpected %q in:\n%s", c.name, w, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,844 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// texToMathML converts a TeX math expression (the subset commonly used in
+// documentation: scripts, fractions, roots, Greek, operators, accents,
+// delimiters, fonts, and matrix/cases/aligned environments) to a MathML
+// <math> element that browsers render natively. Unknown commands become
+// <merror> so the rest of the expression still renders; structural errors
+// such as unbalanced braces are returned.
+func texToMathML(tex string, display bool) (string, error) {
+	tex = strings.TrimSpace(tex)
+	p := &texParser{src: tex, toks: tokenizeTeX(tex), display: display}
+	body, err := p.parseTable(func(t texToken) bool { return t.kind == tokEOF })
+	if err != nil {
+		return "", err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return "", fmt.Errorf("unexpected %q", t.text)
+	}
+
+	var b strings.Builder
+	b.WriteString(`<math xmlns="http://www.w3.org/1998/Math/MathML"`)
+	if display {
+		b.WriteString(` display="block"`)
+	}
+	b.WriteString("><semantics>")
+	b.WriteString(mrow(body))
+	b.WriteString(`<annotation encoding="application/x-tex">`)
+	b.WriteString(html.EscapeString(tex))
+	b.WriteString("</annotation></semantics></math>")
+	return b.String(), nil
+}
+
+type texTokenKind int
+
+const (
+	tokEOF     texTokenKind = iota
+	tokCommand              // \name or \c; text is the name without the backslash
+	tokOpen                 // {
+	tokClose                // }
+	tokSup                  // ^
+	tokSub                  // _
+	tokAmp                  // &
+	tokNewline              // \\
+	tokNumber
+	tokLetter
+	tokPrime
+	tokOther // any other single character
+)
+
+type texToken struct {
+	kind       texTokenKind
+	text       string
+	start, end int // byte offsets into the source
+}
+
+func tokenizeTeX(s string) []texToken {
+	var toks []texToken
+	add := func(kind texTokenKind, start, end int) {
+		toks = append(toks, texToken{kind: kind, text: s[start:end], start: start, end: end})
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '%':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case c == '\\':
+			j := i + 1
+			switch {
+			case j >= len(s):
+				add(tokOther, i, j)
+			case s[j] == '\\':
+				add(tokNewline, i, j+1)
+				j++
+			case isASCIILetter(s[j]):
+				for j < len(s) && isASCIILetter(s[j]) {
+					j++
+				}
+				toks = append(toks, texToken{kind: tokCommand, text: s[i+1 : j], start: i, end: j})
+			default:
+				_, size := utf8.DecodeRuneInString(s[j:])
+				j += size
+				toks = append(toks, texToken{kind: tokCommand, text: s[i+1 : j], start: i, end: j})
+			}
+			i = j
+		case c == '{':
+			add(tokOpen, i, i+1)
+			i++
+		case c == '}':
+			add(tokClose, i, i+1)
+			i++
+		case c == '^':
+			add(tokSup, i, i+1)
+			i++
+		case c == '_':
+			add(tokSub, i, i+1)
+			i++
+		case c == '&':
+			add(tokAmp, i, i+1)
+			i++
+		case c == '\'':
+			add(tokPrime, i, i+1)
+			i++
+		case c == '~':
+			toks = append(toks, texToken{kind: tokCommand, text: " ", start: i, end: i + 1})
+			i++
+		case isDigit(c) || (c == '.' && i+1 < len(s) && isDigit(s[i+1])):
+			j, dot := i, false
+			for j < len(s) && (isDigit(s[j]) || (s[j] == '.' && !dot && j+1 < len(s) && isDigit(s[j+1]))) {
+				dot = dot || s[j] == '.'
+				j++
+			}
+			add(tokNumber, i, j)
+			i = j
+		default:
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if unicode.IsLetter(r) {
+				add(tokLetter, i, i+size)
+			} else {
+				add(tokOther, i, i+size)
+			}
+			i += size
+		}
+	}
+	return toks
+}
+
+func isASCIILetter(c byte) bool { return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' }
+func isDigit(c byte) bool       { return '0' <= c && c <= '9' }
+
+type texParser struct {
+	src     string
+	toks    []texToken
+	pos     int
+	display bool
+	variant string // active \mathbb-style alphabet, if any
+}
+
+func (p *texParser) peek() texToken {
+	if p.pos >= len(p.toks) {
+		return texToken{kind: tokEOF, start: len(p.src), end: len(p.src)}
+	}
+	return p.toks[p.pos]
+}
+
+func (p *texParser) next() texToken {
+	t := p.peek()
+	if p.pos < len(p.toks) {
+		p.pos++
+	}
+	return t
+}
+
+func (p *texParser) isCommand(name string) bool {
+	t := p.peek()
+	return t.kind == tokCommand && t.text == name
+}
+
+// mrow wraps items in an <mrow> unless there is exactly one.
+func mrow(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return "<mrow>" + strings.Join(items, "") + "</mrow>"
+}
+
+// rowEnd reports whether t ends the current row of atoms.
+func rowEnd(t texToken) bool {
+	switch t.kind {
+	case tokEOF, tokClose, tokAmp, tokNewline:
+		return true
+	case tokCommand:
+		return t.text == "right" || t.text == "end" || t.text == "middle"
+	}
+	return false
+}
+
+// parseRow parses atoms until rowEnd or stop matches the next token.
+func (p *texParser) parseRow(stop func(texToken) bool) ([]string, error) {
+	var items []string
+	for {
+		t := p.peek()
+		if rowEnd(t) || (stop != nil && stop(t)) {
+			if t.kind == tokCommand && t.text == "middle" {
+				p.next()
+				d, err := p.parseDelimiter()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, `<mo stretchy="true">`+d+`</mo>`)
+				continue
+			}
+			return items, nil
+		}
+		atom, err := p.parseAtom()
+		if err != nil {
+			return nil, err
+		}
+		if atom != "" {
+			items = append(items, atom)
+		}
+	}
+}
+
+// parseTable parses rows separated by \\ and cells separated by &, until
+// end matches. A single cell is returned as-is; anything else becomes an
+// <mtable>.
+func (p *texParser) parseTable(end func(texToken) bool) ([]string, error) {
+	rows, err := p.parseCells(end)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 1 && len(rows[0]) == 1 {
+		return rows[0][0], nil
+	}
+	return []string{mtable(rows, "")}, nil
+}
+
+func (p *texParser) parseCells(end func(texToken) bool) ([][][]string, error) {
+	var rows [][][]string
+	var row [][]string
+	for {
+		cell, err := p.parseRow(end)
+		if err != nil {
+			return nil, err
+		}
+		row = append(row, cell)
+		switch t := p.peek(); {
+		case t.kind == tokAmp:
+			p.next()
+		case t.kind == tokNewline:
+			p.next()
+			rows = append(rows, row)
+			row = nil
+		case end(t):
+			// A trailing \\ leaves an empty last row; drop it.
+			if len(row) > 1 || len(row[0]) > 0 || len(rows) == 0 {
+				rows = append(rows, row)
+			}
+			return rows, nil
+		default:
+			return nil, fmt.Errorf("unexpected %q", t.text)
+		}
+	}
+}
+
+func mtable(rows [][][]string, attrs string) string {
+	var b strings.Builder
+	b.WriteString("<mtable" + attrs + ">")
+	for _, row := range rows {
+		b.WriteString("<mtr>")
+		for _, cell := range row {
+			b.WriteString("<mtd>" + mrow(cell) + "</mtd>")
+		}
+		b.WriteString("</mtr>")
+	}
+	b.WriteString("</mtable>")
+	return b.String()
+}
+
+// parseAtom parses a base and any scripts attached to it.
+func (p *texParser) parseAtom() (string, error) {
+	var base string
+	var limits bool
+	if t := p.peek(); t.kind == tokSup || t.kind == tokSub || t.kind == tokPrime {
+		base = "<mrow></mrow>"
+	} else {
+		var err error
+		if base, limits, err = p.parseBase(); err != nil {
+			return "", err
+		}
+	}
+	if p.isCommand("limits") {
+		p.next()
+		limits = true
+	} else if p.isCommand("nolimits") {
+		p.next()
+		limits = false
+	}
+
+	var sub, sup []string
+	var haveSub, haveSup bool
+	for {
+		switch t := p.peek(); {
+		case t.kind == tokPrime && !haveSup:
+			p.next()
+			sup = append(sup, "<mo>′</mo>")
+		case t.kind == tokSup && !haveSup:
+			p.next()
+			arg, err := p.parseArg()
+			if err != nil {
+				return "", err
+			}
+			sup = append(sup, arg)
+			haveSup = true
+		case t.kind == tokSub && !haveSub:
+			p.next()
+			arg, err := p.parseArg()
+			if err != nil {
+				return "", err
+			}
+			sub = append(sub, arg)
+			haveSub = true
+		default:
+			return scripted(base, sub, sup, limits), nil
+		}
+	}
+}
+
+func scripted(base string, sub, sup []string, limits bool) string {
+	under, over := "msub", "msup"
+	both := "msubsup"
+	if limits {
+		under, over, both = "munder", "mover", "munderover"
+	}
+	switch {
+	case sub != nil && sup != nil:
+		return "<" + both + ">" + base + mrow(sub) + mrow(sup) + "</" + both + ">"
+	case sub != nil:
+		return "<" + under + ">" + base + mrow(sub) + "</" + under + ">"
+	case sup != nil:
+		return "<" + over + ">" + base + mrow(sup) + "</" + over + ">"
+	}
+	return base
+}
+
+// parseArg parses a macro argument, or the argument of ^ or _: a single
+// token or group, so x^10 is x^{1}0 and \frac12 is \frac{1}{2}.
+func (p *texParser) parseArg() (string, error) {
+	t := p.peek()
+	if rowEnd(t) {
+		return "", errors.New("missing argument")
+	}
+	if t.kind == tokNumber && len(t.text) > 1 {
+		p.toks[p.pos].text = t.text[1:]
+		p.toks[p.pos].start++
+		return p.number(t.text[:1]), nil
+	}
+	s, _, err := p.parseBase()
+	return s, err
+}
+
+// rawGroup returns the source text of a {...} group without interpreting
+// it, for \text and friends where spaces matter.
+func (p *texParser) rawGroup() (string, error) {
+	open := p.next()
+	if open.kind != tokOpen {
+		return open.text, nil
+	}
+	for depth := 1; ; {
+		t := p.next()
+		switch t.kind {
+		case tokEOF:
+			return "", errors.New("unbalanced braces")
+		case tokOpen:
+			depth++
+		case tokClose:
+			if depth--; depth == 0 {
+				return p.src[open.end:t.start], nil
+			}
+		}
+	}
+}
+
+// parseBase parses a single group, token, or command. limits reports
+// whether scripts on it go above and below rather than to the side.
+func (p *texParser) parseBase() (s string, limits bool, err error) {
+	t := p.next()
+	switch t.kind {
+	case tokOpen:
+		items, err := p.parseRow(nil)
+		if err != nil {
+			return "", false, err
+		}
+		if p.next().kind != tokClose {
+			return "", false, errors.New("unbalanced braces")
+		}
+		if len(items) == 0 {
+			return "<mrow></mrow>", false, nil
+		}
+		return mrow(items), false, nil
+	case tokNumber:
+		return p.number(t.text), false, nil
+	case tokLetter:
+		return p.identifier(t.text), false, nil
+	case tokOther:
+		return p.operator(t.text), false, nil
+	case tokCommand:
+		return p.parseCommand(t)
+	}
+	return "", false, fmt.Errorf("unexpected %q", t.text)
+}
+
+func (p *texParser) number(s string) string {
+	if p.variant != "" {
+		return "<mn>" + mathAlphabet(p.variant, s) + "</mn>"
+	}
+	return "<mn>" + s + "</mn>"
+}
+
+func (p *texParser) identifier(s string) string {
+	if p.variant != "" {
+		if p.variant == "rm" {
+			return `<mi mathvariant="normal">` + html.EscapeString(s) + "</mi>"
+		}
+		return "<mi>" + mathAlphabet(p.variant, s) + "</mi>"
+	}
+	return "<mi>" + html.EscapeString(s) + "</mi>"
+}
+
+func (p *texParser) operator(s string) string {
+	switch s {
+	case "-":
+		s = "−"
+	case "*":
+		s = "∗"
+	case "(", ")", "[", "]", "|", "/":
+		// Unlike MathML's operator dictionary, TeX only stretches
+		// delimiters when asked to with \left and \right.
+		return `<mo stretchy="false">` + s + "</mo>"
+	}
+	return "<mo>" + html.EscapeString(s) + "</mo>"
+}
+
+func (p *texParser) parseCommand(t texToken) (string, bool, error) {
+	name := t.text
+	if s, ok := texIdentifiers[name]; ok {
+		if r, _ := utf8.DecodeRuneInString(s); unicode.IsUpper(r) {
+			return `<mi mathvariant="normal">` + s + "</mi>", false, nil // upright, as TeX sets capital Greek
+		}
+		return "<mi>" + s + "</mi>", false, nil
+	}
+	if s, ok := texOperators[name]; ok {
+		if strings.ContainsAny(s, "{}⟨⟩⌊⌋⌈⌉|‖") {
+			return `<mo stretchy="false">` + s + "</mo>", false, nil
+		}
+		return "<mo>" + html.EscapeString(s) + "</mo>", false, nil
+	}
+	if s, ok := texBigOperators[name]; ok {
+		return `<mo largeop="true" movablelimits="true">` + s + "</mo>", p.display, nil
+	}
+	if s, ok := texIntegrals[name]; ok {
+		return `<mo largeop="true">` + s + "</mo>", false, nil
+	}
+	if texFunctions[name] {
+		return "<mi>" + name + "</mi>", false, nil
+	}
+	if texLimitFunctions[name] {
+		fn := name
+		switch name {
+		case "liminf":
+			fn = "lim inf"
+		case "limsup":
+			fn = "lim sup"
+		}
+		return "<mi>" + fn + "</mi>", p.display, nil
+	}
+	if w, ok := texSpaces[name]; ok {
+		return `<mspace width="` + w + `"></mspace>`, false, nil
+	}
+	if acc, ok := texAccents[name]; ok {
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		stretchy := "false"
+		if strings.HasPrefix(name, "wide") || strings.HasPrefix(name, "over") {
+			stretchy = "true"
+		}
+		return `<mover accent="true">` + arg + `<mo stretchy="` + stretchy + `">` + acc + "</mo></mover>", false, nil
+	}
+
+	switch name {
+	case "frac", "dfrac", "tfrac", "cfrac":
+		num, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		den, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		return "<mfrac>" + num + den + "</mfrac>", false, nil
+	case "binom", "dbinom", "tbinom":
+		n, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		k, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		return `<mrow><mo>(</mo><mfrac linethickness="0">` + n + k + "</mfrac><mo>)</mo></mrow>", false, nil
+	case "sqrt":
+		var index []string
+		if t := p.peek(); t.kind == tokOther && t.text == "[" {
+			p.next()
+			var err error
+			index, err = p.parseRow(func(t texToken) bool { return t.kind == tokOther && t.text == "]" })
+			if err != nil {
+				return "", false, err
+			}
+			p.next()
+		}
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		if index != nil {
+			return "<mroot>" + arg + mrow(index) + "</mroot>", false, nil
+		}
+		return "<msqrt>" + arg + "</msqrt>", false, nil
+	case "text", "textrm", "textnormal", "textup", "mbox", "textit", "textbf":
+		s, err := p.rawGroup()
+		if err != nil {
+			return "", false, err
+		}
+		return "<mtext>" + html.EscapeString(s) + "</mtext>", false, nil
+	case "operatorname":
+		limits := false
+		if t := p.peek(); t.kind == tokOther && t.text == "*" {
+			p.next()
+			limits = p.display
+		}
+		s, err := p.rawGroup()
+		if err != nil {
+			return "", false, err
+		}
+		return "<mi>" + html.EscapeString(strings.Join(strings.Fields(s), "")) + "</mi>", limits, nil
+	case "mathrm", "mathbf", "mathbb", "mathcal", "mathscr", "mathfrak", "mathsf", "mathtt", "mathit", "boldsymbol", "bm":
+		saved := p.variant
+		p.variant = strings.TrimPrefix(name, "math")
+		arg, err := p.parseArg()
+		p.variant = saved
+		return arg, false, err
+	case "overset", "stackrel", "underset":
+		over, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		base, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		if name == "underset" {
+			return "<munder>" + base + over + "</munder>", false, nil
+		}
+		return "<mover>" + base + over + "</mover>", false, nil
+	case "overbrace", "underbrace":
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		if name == "overbrace" {
+			return `<mover>` + arg + `<mo stretchy="true">⏞</mo></mover>`, true, nil
+		}
+		return `<munder>` + arg + `<mo stretchy="true">⏟</mo></munder>`, true, nil
+	case "underline":
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		return `<munder accentunder="true">` + arg + `<mo stretchy="true">_</mo></munder>`, false, nil
+	case "not":
+		next, _, err := p.parseBase()
+		if err != nil {
+			return "", false, err
+		}
+		switch next {
+		case "<mo>=</mo>":
+			return "<mo>≠</mo>", false, nil
+		case "<mo>∈</mo>":
+			return "<mo>∉</mo>", false, nil
+		}
+		if inner, ok := strings.CutSuffix(next, "</mo>"); ok && strings.HasPrefix(inner, "<mo") {
+			return inner + "\u0338</mo>", false, nil
+		}
+		return next, false, nil
+	case "left":
+		return p.parseLeftRight()
+	case "big", "Big", "bigg", "Bigg",
+		"bigl", "Bigl", "biggl", "Biggl",
+		"bigr", "Bigr", "biggr", "Biggr",
+		"bigm", "Bigm", "biggm", "Biggm":
+		d, err := p.parseDelimiter()
+		if err != nil {
+			return "", false, err
+		}
+		size := texBigSizes[strings.TrimRight(name, "lrm")]
+		return `<mo minsize="` + size + `" maxsize="` + size + `">` + d + "</mo>", false, nil
+	case "begin":
+		return p.parseEnvironment()
+	case "bmod", "mod":
+		return `<mo lspace="0.2222em" rspace="0.2222em">mod</mo>`, false, nil
+	case "pmod":
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		return `<mrow><mspace width="1em"></mspace><mo>(</mo><mi>mod</mi><mspace width="0.3333em"></mspace>` + arg + "<mo>)</mo></mrow>", false, nil
+	case "displaystyle", "textstyle", "scriptstyle", "scriptscriptstyle", "limits", "nolimits":
+		return "", false, nil
+	}
+	return "<merror><mtext>" + html.EscapeString(`\`+name) + "</mtext></merror>", false, nil
+}
+
+// parseDelimiter parses the delimiter after \left, \right, \middle or \big.
+func (p *texParser) parseDelimiter() (string, error) {
+	t := p.next()
+	switch t.kind {
+	case tokOther:
+		if t.text == "." {
+			return "", nil
+		}
+		return html.EscapeString(t.text), nil
+	case tokCommand:
+		if s, ok := texOperators[t.text]; ok {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("bad delimiter %q", t.text)
+}
+
+func (p *texParser) parseLeftRight() (string, bool, error) {
+	open, err := p.parseDelimiter()
+	if err != nil {
+		return "", false, err
+	}
+	items, err := p.parseRow(nil)
+	if err != nil {
+		return "", false, err
+	}
+	if !p.isCommand("right") {
+		return "", false, errors.New(`\left without \right`)
+	}
+	p.next()
+	closing, err := p.parseDelimiter()
+	if err != nil {
+		return "", false, err
+	}
+	return `<mrow><mo fence="true" form="prefix">` + open + "</mo>" + strings.Join(items, "") +
+		`<mo fence="true" form="postfix">` + closing + "</mo></mrow>", false, nil
+}
+
+func (p *texParser) parseEnvironment() (string, bool, error) {
+	name, err := p.rawGroup()
+	if err != nil {
+		return "", false, err
+	}
+	name = strings.TrimSpace(name)
+	if name == "array" || name == "alignedat" {
+		if _, err := p.rawGroup(); err != nil { // column spec; alignment is left to the defaults
+			return "", false, err
+		}
+	}
+	rows, err := p.parseCells(func(t texToken) bool { return t.kind == tokCommand && t.text == "end" })
+	if err != nil {
+		return "", false, err
+	}
+	if !p.isCommand("end") {
+		return "", false, fmt.Errorf(`\begin{%s} without \end`, name)
+	}
+	p.next()
+	if end, err := p.rawGroup(); err != nil {
+		return "", false, err
+	} else if strings.TrimSpace(end) != name {
+		return "", false, fmt.Errorf(`\begin{%s} ended by \end{%s}`, name, end)
+	}
+
+	fenced := func(open, close, attrs string) string {
+		return `<mrow><mo fence="true" form="prefix">` + open + "</mo>" + mtable(rows, attrs) +
+			`<mo fence="true" form="postfix">` + close + "</mo></mrow>"
+	}
+	switch name {
+	case "pmatrix":
+		return fenced("(", ")", ""), false, nil
+	case "bmatrix":
+		return fenced("[", "]", ""), false, nil
+	case "Bmatrix":
+		return fenced("{", "}", ""), false, nil
+	case "vmatrix":
+		return fenced("|", "|", ""), false, nil
+	case "Vmatrix":
+		return fenced("‖", "‖", ""), false, nil
+	case "cases":
+		return fenced("{", "", ` columnalign="left left"`), false, nil
+	case "rcases":
+		return fenced("", "}", ` columnalign="left left"`), false, nil
+	case "aligned", "align", "align*", "alignedat", "split", "eqnarray", "eqnarray*":
+		return mtable(rows, ` columnalign="right left right left right left" columnspacing="0em 2em"`), false, nil
+	}
+	if len(rows) == 1 && len(rows[0]) == 1 {
+		return mrow(rows[0][0]), false, nil // equation, gathered with one line, etc.
+	}
+	return mtable(rows, ""), false, nil
+}
+
+var texIdentifiers = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ",
+	"varepsilon": "ε", "zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ",
+	"iota": "ι", "kappa": "κ", "lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ",
+	"pi": "π", "varpi": "ϖ", "rho": "ρ", "varrho": "ϱ", "sigma": "σ",
+	"varsigma": "ς", "tau": "τ", "upsilon": "υ", "phi": "ϕ", "varphi": "φ",
+	"chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ",
+	"Pi": "Π", "Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+	"infty": "∞", "partial": "∂", "nabla": "∇", "hbar": "ℏ", "ell": "ℓ",
+	"emptyset": "∅", "varnothing": "∅", "aleph": "ℵ", "Re": "ℜ", "Im": "ℑ",
+	"wp": "℘", "imath": "ı", "jmath": "ȷ", "top": "⊤", "bot": "⊥",
+	"angle": "∠", "triangle": "△", "checkmark": "✓",
+}
+
+var texOperators = map[string]string{
+	"times": "×", "cdot": "⋅", "div": "÷", "pm": "±", "mp": "∓", "ast": "∗",
+	"star": "⋆", "circ": "∘", "bullet": "∙", "oplus": "⊕", "ominus": "⊖",
+	"otimes": "⊗", "odot": "⊙", "cap": "∩", "cup": "∪", "wedge": "∧",
+	"land": "∧", "vee": "∨", "lor": "∨", "neg": "¬", "lnot": "¬",
+	"setminus": "∖", "leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠",
+	"ne": "≠", "approx": "≈", "equiv": "≡", "sim": "∼", "simeq": "≃",
+	"cong": "≅", "propto": "∝", "ll": "≪", "gg": "≫", "subset": "⊂",
+	"supset": "⊃", "subseteq": "⊆", "supseteq": "⊇", "in": "∈", "notin": "∉",
+	"ni": "∋", "perp": "⊥", "parallel": "∥", "mid": "∣", "models": "⊨",
+	"vdash": "⊢", "to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←",
+	"leftrightarrow": "↔", "Rightarrow": "⇒", "Leftarrow": "⇐",
+	"Leftrightarrow": "⇔", "iff": "⟺", "implies": "⟹", "impliedby": "⟸",
+	"mapsto": "↦", "longrightarrow": "⟶", "longleftarrow": "⟵",
+	"Longrightarrow": "⟹", "Longleftarrow": "⟸", "longmapsto": "⟼",
+	"uparrow": "↑", "downarrow": "↓", "Uparrow": "⇑", "Downarrow": "⇓",
+	"forall": "∀", "exists": "∃", "nexists": "∄", "therefore": "∴",
+	"because": "∵", "ldots": "…", "dots": "…", "cdots": "⋯", "vdots": "⋮",
+	"ddots": "⋱", "colon": ":", "prime": "′",
+	"langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈",
+	"rceil": "⌉", "vert": "|", "lvert": "|", "rvert": "|", "Vert": "‖",
+	"lVert": "‖", "rVert": "‖", "|": "‖", "{": "{", "}": "}", "lbrace": "{",
+	"rbrace": "}", "backslash": "∖",
+	"$": "$", "%": "%", "#": "#", "&": "&", "_": "_",
+}
+
+var texBigOperators = map[string]string{
+	"sum": "∑", "prod": "∏", "coprod": "∐", "bigcup": "⋃", "bigcap": "⋂",
+	"bigoplus": "⨁", "bigotimes": "⨂", "bigodot": "⨀", "bigvee": "⋁",
+	"bigwedge": "⋀", "bigsqcup": "⨆",
+}
+
+var texIntegrals = map[string]string{
+	"int": "∫", "iint": "∬", "iiint": "∭", "oint": "∮",
+}
+
+var texFunctions = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "cot": true, "sec": true, "csc": true,
+	"arcsin": true, "arccos": true, "arctan": true, "sinh": true, "cosh": true,
+	"tanh": true, "coth": true, "log": true, "ln": true, "lg": true, "exp": true,
+	"deg": true, "dim": true, "hom": true, "ker": true, "arg": true,
+}
+
+var texLimitFunctions = map[string]bool{
+	"lim": true, "liminf": true, "limsup": true, "max": true, "min": true,
+	"sup": true, "inf": true, "det": true, "gcd": true, "Pr": true,
+}
+
+var texSpaces = map[string]string{
+	",": "0.1667em", ":": "0.2222em", ">": "0.2222em", ";": "0.2778em",
+	"!": "-0.1667em", " ": "0.25em", "quad": "1em", "qquad": "2em",
+	"thinspace": "0.1667em", "enspace": "0.5em",
+}
+
+var texAccents = map[string]string{
+	"hat": "^", "widehat": "^", "bar": "¯", "overline": "¯", "vec": "→",
+	"overrightarrow": "→", "overleftarrow": "←", "dot": "˙", "ddot": "¨",
+	"tilde": "~", "widetilde": "~", "check": "ˇ", "breve": "˘", "acute": "´",
+	"grave": "`",
+}
+
+var texBigSizes = map[string]string{
+	"big": "1.2em", "Big": "1.8em", "bigg": "2.4em", "Bigg": "3em",
+}
+
+// mathAlphabets gives the first code point of the capital letters, small
+// letters, and digits of each Mathematical Alphanumeric Symbols alphabet,
+// keyed by the \math* command suffix that selects it.
+var mathAlphabets = map[string][3]rune{
+	"bf":         {0x1D400, 0x1D41A, 0x1D7CE},
+	"it":         {0x1D434, 0x1D44E, 0},
+	"boldsymbol": {0x1D468, 0x1D482, 0x1D7CE},
+	"bm":         {0x1D468, 0x1D482, 0x1D7CE},
+	"cal":        {0x1D49C, 0x1D4B6, 0},
+	"scr":        {0x1D49C, 0x1D4B6, 0},
+	"frak":       {0x1D504, 0x1D51E, 0},
+	"bb":         {0x1D538, 0x1D552, 0x1D7D8},
+	"sf":         {0x1D5A0, 0x1D5BA, 0x1D7E2},
+	"tt":         {0x1D670, 0x1D68A, 0x1D7F6},
+}
+
+// mathAlphabetHoles are letters that Unicode encoded in the Letterlike
+// Symbols block before the Mathematical Alphanumeric Symbols existed.
+var mathAlphabetHoles = map[string]map[rune]rune{
+	"it":   {'h': 'ℎ'},
+	"cal":  {'B': 'ℬ', 'E': 'ℰ', 'F': 'ℱ', 'H': 'ℋ', 'I': 'ℐ', 'L': 'ℒ', 'M': 'ℳ', 'R': 'ℛ', 'e': 'ℯ', 'g': 'ℊ', 'o': 'ℴ'},
+	"scr":  {'B': 'ℬ', 'E': 'ℰ', 'F': 'ℱ', 'H': 'ℋ', 'I': 'ℐ', 'L': 'ℒ', 'M': 'ℳ', 'R': 'ℛ', 'e': 'ℯ', 'g': 'ℊ', 'o': 'ℴ'},
+	"frak": {'C': 'ℭ', 'H': 'ℌ', 'I': 'ℑ', 'R': 'ℜ', 'Z': 'ℨ'},
+	"bb":   {'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ'},
+}
+
+// mathAlphabet maps ASCII letters and digits in s to the given alphabet,
+// leaving anything it has no glyph for unchanged.
+func mathAlphabet(variant, s string) string {
+	base, ok := mathAlphabets[variant]
+	if !ok {
+		return html.EscapeString(s)
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if h, ok := mathAlphabetHoles[variant][r]; ok {
+			b.WriteRune(h)
+			continue
+		}
+		switch {
+		case 'A' <= r && r <= 'Z':
+			b.WriteRune(base[0] + r - 'A')
+		case 'a' <= r && r <= 'z':
+			b.WriteRune(base[1] + r - 'a')
+		case '0' <= r && r <= '9' && base[2] != 0:
+			b.WriteRune(base[2] + r - '0')
+		default:
+			b.WriteString(html.EscapeString(string(r)))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTexToMathML(t *testing.T) {
+	cases := []struct {
+		tex     string
+		display bool
+		want    string // expected <semantics> body, before the annotation
+	}{
+		{`x^2`, false, `<msup><mi>x</mi><mn>2</mn></msup>`},
+		{`x^10`, false, `<mrow><msup><mi>x</mi><mn>1</mn></msup><mn>0</mn></mrow>`},
+		{`a_i^2`, false, `<msubsup><mi>a</mi><mi>i</mi><mn>2</mn></msubsup>`},
+		{`f'`, false, `<msup><mi>f</mi><mo>′</mo></msup>`},
+		{`\frac12`, false, `<mfrac><mn>1</mn><mn>2</mn></mfrac>`},
+		{`\sqrt[n]{x}`, false, `<mroot><mi>x</mi><mi>n</mi></mroot>`},
+		{`a - b < c`, false, `<mrow><mi>a</mi><mo>−</mo><mi>b</mi><mo>&lt;</mo><mi>c</mi></mrow>`},
+		{`\alpha\Gamma`, false, `<mrow><mi>α</mi><mi mathvariant="normal">Γ</mi></mrow>`},
+		{`\sum_{i}`, false, `<msub><mo largeop="true" movablelimits="true">∑</mo><mi>i</mi></msub>`},
+		{`\sum_{i}`, true, `<munder><mo largeop="true" movablelimits="true">∑</mo><mi>i</mi></munder>`},
+		{`\int_0^1`, true, `<msubsup><mo largeop="true">∫</mo><mn>0</mn><mn>1</mn></msubsup>`},
+		{`\text{a  b}`, false, `<mtext>a  b</mtext>`},
+		{`\mathbb{R}\mathbf{x}`, false, `<mrow><mi>ℝ</mi><mi>𝐱</mi></mrow>`},
+		{`\hat{x}`, false, `<mover accent="true"><mi>x</mi><mo stretchy="false">^</mo></mover>`},
+		{`\left(x\right]`, false, `<mrow><mo fence="true" form="prefix">(</mo><mi>x</mi><mo fence="true" form="postfix">]</mo></mrow>`},
+		{`\not\in`, false, `<mo>∉</mo>`},
+		{`\begin{bmatrix}1&2\\3&4\end{bmatrix}`, true,
+			`<mrow><mo fence="true" form="prefix">[</mo><mtable><mtr><mtd><mn>1</mn></mtd><mtd><mn>2</mn></mtd></mtr>` +
+				`<mtr><mtd><mn>3</mn></mtd><mtd><mn>4</mn></mtd></mtr></mtable><mo fence="true" form="postfix">]</mo></mrow>`},
+		{`a \\ b \\`, true, `<mtable><mtr><mtd><mi>a</mi></mtd></mtr><mtr><mtd><mi>b</mi></mtd></mtr></mtable>`},
+		{`\unknown x`, false, `<mrow><merror><mtext>\unknown</mtext></merror><mi>x</mi></mrow>`},
+	}
+	for _, c := range cases {
+		got, err := texToMathML(c.tex, c.display)
+		if err != nil {
+			t.Errorf("texToMathML(%q): %v", c.tex, err)
+			continue
+		}
+		_, body, _ := strings.Cut(got, "<semantics>")
+		body, _, _ = strings.Cut(body, "<annotation")
+		if body != c.want {
+			t.Errorf("texToMathML(%q, %v) =\n%s\nwant\n%s", c.tex, c.display, body, c.want)
+		}
+	}
+}
+
+func TestTexToMathMLErrors(t *testing.T) {
+	for _, tex := range []string{`\frac{a`, `a}`, `\left( x`, `\begin{matrix} x \end{pmatrix}`, `\sqrt`} {
+		if got, err := texToMathML(tex, false); err == nil {
+			t.Errorf("texToMathML(%q) = %s, want error", tex, got)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.abhg.dev/goldmark/mermaid"
+)
+
+// mermaidVersion is the release of mermaid.min.js (MIT licensed) embedded
+// in the binary. Bump it together with the file.
+const mermaidVersion = "10.6.0"
+
+//go:embed mermaid.min.js
+var mermaidJS string
+
+// mermaidJSPath is the reserved URL live pages load the embedded bundle
+// from. It carries the version so browsers can cache it indefinitely.
+const mermaidJSPath = "/.serve/mermaid-" + mermaidVersion + ".min.js"
+
+// diagrams renders ```mermaid blocks. If the mermaid CLI (mmdc) is on
+// $PATH, diagrams are pre-rendered to inline SVG; otherwise pages draw them
+// in the browser with the embedded bundle, so neither needs a CDN.
+var diagrams = &mermaid.Extender{MermaidURL: mermaidJSPath}
+
+// serveMermaidJS serves the embedded mermaid bundle at mermaidJSPath.
+func serveMermaidJS(w http.ResponseWriter, r *http.Request, path string) bool {
+	if path != mermaidJSPath {
+		return false
+	}
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, "", time.Time{}, strings.NewReader(mermaidJS))
+	return true
+}
+
+// inlineMermaidJS replaces a page's reference to mermaidJSPath with the
+// bundle itself, so downloaded and exported pages draw diagrams offline.
+// Pages without diagrams don't reference it and are returned unchanged.
+func inlineMermaidJS(html []byte) []byte {
+	ref := []byte(`<script src="` + mermaidJSPath + `"></script>`)
+	if !bytes.Contains(html, ref) {
+		return html
+	}
+	// Guard against the bundle ever ending the script element early.
+	js := strings.ReplaceAll(mermaidJS, "</script", `<\/script`)
+	return bytes.Replace(html, ref, []byte("<script>"+js+"</script>"), 1)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+const diagramDoc = "# Flow\n\n```mermaid\ngraph TD; A-->B\n```\n"
+
+func skipIfMMDC(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("mmdc"); err == nil {
+		t.Skip("mmdc on $PATH renders diagrams server-side")
+	}
+}
+
+func TestServeMarkdownLoadsEmbeddedMermaid(t *testing.T) {
+	skipIfMMDC(t)
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "flow.md", diagramDoc, time.Time{})
+
+	rec := httptest.NewRecorder()
+	if !serveMarkdown(rec, httptest.NewRequest("GET", "/flow.md", nil), "/flow.md") {
+		t.Fatal("serveMarkdown returned false")
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `<script src="`+mermaidJSPath+`"></script>`) {
+		t.Errorf("page doesn't load the embedded bundle: %s", body)
+	}
+	if strings.Contains(body, "cdn.jsdelivr.net") {
+		t.Error("page still references the CDN")
+	}
+}
+
+func TestServeMermaidJS(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if !serveMermaidJS(rec, httptest.NewRequest("GET", mermaidJSPath, nil), mermaidJSPath) {
+		t.Fatal("serveMermaidJS returned false")
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/javascript") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if rec.Body.String() != mermaidJS {
+		t.Errorf("body is %d bytes, want the %d byte bundle", rec.Body.Len(), len(mermaidJS))
+	}
+	if serveMermaidJS(httptest.NewRecorder(), httptest.NewRequest("GET", "/other.js", nil), "/other.js") {
+		t.Error("serveMermaidJS handled an unrelated path")
+	}
+}
+
+func TestServeMarkdownDownloadInlinesMermaid(t *testing.T) {
+	skipIfMMDC(t)
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "flow.md", diagramDoc, time.Time{})
+	writeFile(t, dir, "plain.md", "# Plain\n", time.Time{})
+
+	rec := httptest.NewRecorder()
+	serveMarkdown(rec, httptest.NewRequest("GET", "/flow.md?download", nil), "/flow.md")
+	html := zipBody(t, readZip(t, rec.Body.Bytes())["flow.html"])
+	if strings.Contains(html, mermaidJSPath) || !strings.Contains(html, "<script>"+mermaidJS[:100]) {
+		t.Error("standalone page should inline the mermaid bundle")
+	}
+
+	rec = httptest.NewRecorder()
+	serveMarkdown(rec, httptest.NewRequest("GET", "/plain.md?download", nil), "/plain.md")
+	if html := zipBody(t, readZip(t, rec.Body.Bytes())["plain.html"]); len(html) > len(mermaidJS) {
+		t.Error("page without diagrams should not carry the bundle")
+	}
+}
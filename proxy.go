@@ -0,0 +1,165 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// proxyRoute sends requests under a path prefix to an upstream server.
+type proxyRoute struct {
+	prefix string // "/api/", or "/" for everything
+	target *url.URL
+	strip  bool // remove prefix from the path sent upstream
+	rp     *httputil.ReverseProxy
+}
+
+// routeList is the value of the repeatable -route flag.
+type routeList []string
+
+func (l *routeList) String() string { return strings.Join(*l, " ") }
+
+func (l *routeList) Set(spec string) error {
+	if spec == "" {
+		return nil // -route= clears the saved routes
+	}
+	if _, err := parseRoute(spec); err != nil {
+		return err
+	}
+	*l = append(*l, spec)
+	return nil
+}
+
+func routesFlag(name, usage string) *routeList {
+	l := new(routeList)
+	flag.Var(l, name, usage)
+	return l
+}
+
+// parseRoute parses a route given as PREFIX=URL, or PREFIX=URL,strip to
+// remove the prefix before passing requests on, for example
+// /api/=http://127.0.0.1:8000,strip.
+func parseRoute(spec string) (*proxyRoute, error) {
+	prefix, rest, ok := strings.Cut(strings.TrimSpace(spec), "=")
+	if !ok || !strings.HasPrefix(prefix, "/") {
+		return nil, fmt.Errorf("route %q: want /prefix/=http://host:port", spec)
+	}
+	target, opt, _ := strings.Cut(rest, ",")
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("route %q: bad upstream URL %q", spec, target)
+	}
+	rt := &proxyRoute{prefix: prefix, target: u}
+	switch opt {
+	case "":
+	case "strip":
+		rt.strip = true
+	default:
+		return nil, fmt.Errorf("route %q: unknown option %q", spec, opt)
+	}
+	rt.rp = &httputil.ReverseProxy{Rewrite: rt.rewrite}
+	return rt, nil
+}
+
+func (rt *proxyRoute) rewrite(pr *httputil.ProxyRequest) {
+	if rt.strip {
+		base := strings.TrimSuffix(rt.prefix, "/")
+		pr.Out.URL.Path = "/" + strings.TrimLeft(strings.TrimPrefix(pr.Out.URL.Path, base), "/")
+		if pr.Out.URL.RawPath != "" {
+			pr.Out.URL.RawPath = "/" + strings.TrimLeft(strings.TrimPrefix(pr.Out.URL.RawPath, base), "/")
+		}
+	}
+	pr.SetURL(rt.target)
+	// pr.SetXForwarded() is NOT called to keep it "pure"
+	// Additionally strip any proxy headers that might have been present in the original request
+	pr.Out.Header.Del("X-Forwarded-For")
+	pr.Out.Header.Del("X-Forwarded-Host")
+	pr.Out.Header.Del("X-Forwarded-Proto")
+	setIdentityHeaders(pr.In.Context(), pr.Out.Header)
+}
+
+// matches reports whether the request path p falls under the route. A
+// prefix of /api/ matches /api as well as everything below it.
+func (rt *proxyRoute) matches(p string) bool {
+	base := strings.TrimSuffix(rt.prefix, "/")
+	return p == base || strings.HasPrefix(p, base+"/")
+}
+
+// newProxyRoutes parses the route specs, adding catchAll (the -proxy URL)
+// as the route for everything else if it's set. Longer prefixes come
+// first so that they win.
+func newProxyRoutes(specs []string, catchAll string) ([]*proxyRoute, error) {
+	if catchAll != "" {
+		specs = append(specs[:len(specs):len(specs)], "/="+catchAll)
+	}
+	var routes []*proxyRoute
+	for _, spec := range specs {
+		rt, err := parseRoute(spec)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range routes {
+			if strings.TrimSuffix(other.prefix, "/") == strings.TrimSuffix(rt.prefix, "/") {
+				return nil, fmt.Errorf("route %q: %s is already routed to %s", spec, other.prefix, other.target)
+			}
+		}
+		routes = append(routes, rt)
+	}
+	slices.SortStableFunc(routes, func(a, b *proxyRoute) int {
+		return cmp.Compare(len(b.prefix), len(a.prefix))
+	})
+	return routes, nil
+}
+
+// matchRoute returns the route for the request path p, or nil if it should
+// be served from files.
+func matchRoute(routes []*proxyRoute, p string) *proxyRoute {
+	for _, rt := range routes {
+		if rt.matches(p) {
+			return rt
+		}
+	}
+	return nil
+}
+
+// readRoutes reads saved routes, one per line in -route syntax. Blank lines
+// and lines starting with # are skipped.
+func readRoutes(file string) ([]string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var specs []string
+	for line := range strings.Lines(string(content)) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		specs = append(specs, line)
+	}
+	return specs, nil
+}
+
+// routesDesc describes the routes for the startup message.
+func routesDesc(routes []*proxyRoute) string {
+	var parts []string
+	for _, rt := range routes {
+		if rt.prefix == "/" {
+			continue
+		}
+		s := rt.prefix + " → " + rt.target.String()
+		if rt.strip {
+			s += " (stripped)"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, ", ")
+}
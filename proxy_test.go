@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseRoute(t *testing.T) {
+	rt, err := parseRoute("/api/=http://127.0.0.1:8000,strip")
+	if err != nil || rt.prefix != "/api/" || rt.target.Host != "127.0.0.1:8000" || !rt.strip {
+		t.Errorf("parseRoute = %+v, %v", rt, err)
+	}
+	for _, spec := range []string{
+		"api=http://127.0.0.1:8000",
+		"/api/",
+		"/api/=127.0.0.1:8000",
+		"/api/=ftp://example.com",
+		"/api/=http://127.0.0.1:8000,rewrite",
+	} {
+		if _, err := parseRoute(spec); err == nil {
+			t.Errorf("parseRoute(%q) succeeded", spec)
+		}
+	}
+	if _, err := newProxyRoutes([]string{"/api/=http://a", "/api=http://b"}, ""); err == nil {
+		t.Error("duplicate prefix accepted")
+	}
+}
+
+func TestProxyRoutes(t *testing.T) {
+	upstream := func(name string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name+" "+r.URL.RequestURI())
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	api, ws, app := upstream("api"), upstream("ws"), upstream("app")
+
+	routes, err := newProxyRoutes([]string{
+		"/api/=" + api.URL + ",strip",
+		"/api/v2/=" + api.URL + "/next",
+		"/ws/=" + ws.URL,
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		"/api/users?id=1": "api /users?id=1",
+		"/api":            "api /",
+		"/api/v2/users":   "api /next/api/v2/users",
+		"/ws/socket":      "ws /ws/socket",
+		"/apiary":         "",
+		"/index.html":     "",
+	} {
+		rt := matchRoute(routes, path)
+		if rt == nil {
+			if want != "" {
+				t.Errorf("%s: no route, want %q", path, want)
+			}
+			continue
+		}
+		rec := httptest.NewRecorder()
+		rt.rp.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if got := rec.Body.String(); got != want {
+			t.Errorf("%s: got %q, want %q", path, got, want)
+		}
+	}
+
+	// -proxy catches everything the routes don't.
+	routes, err = newProxyRoutes([]string{"/api/=" + api.URL + ",strip"}, app.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt := matchRoute(routes, "/apiary"); rt == nil || rt.target.String() != app.URL {
+		t.Errorf("/apiary routed to %v, want %s", rt, app.URL)
+	}
+}
+
+func TestReadRoutes(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "routes", "# dev stack\n/api/=http://127.0.0.1:8000,strip\n\n/ws/=http://127.0.0.1:9000\n", time.Time{})
+	got, err := readRoutes(filepath.Join(dir, "routes"))
+	want := []string{"/api/=http://127.0.0.1:8000,strip", "/ws/=http://127.0.0.1:9000"}
+	if err != nil || !slices.Equal(got, want) {
+		t.Errorf("readRoutes = %q, %v; want %q", got, err, want)
+	}
+}
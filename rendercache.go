@@ -0,0 +1,233 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// renderCacheStatsPath serves the render cache's counters as JSON.
+const renderCacheStatsPath = "/.serve/render-cache"
+
+// renderCache holds rendered markdown so that pages which haven't changed
+// aren't converted again on every view and export. Entries are found by the
+// file's path, size and modification time and the root it was rendered
+// under, then checked against the files it included and, for pages with
+// [[links]], the pages those resolve among. The least recently used entries
+// are dropped to stay within maxBytes.
+type renderCache struct {
+	mu       sync.Mutex
+	maxBytes int64 // zero disables caching
+	bytes    int64
+	lru      *list.List // of *renderEntry, most recently used first
+	entries  map[renderKey]*list.Element
+
+	hits, misses, evictions int64
+}
+
+type renderKey struct {
+	path string // absolute
+	root string // absolute; includes starting with "/" resolve against it
+	size int64
+	mod  int64  // UnixNano
+	css  string // cssVersion when rendered
+}
+
+type renderEntry struct {
+	key    renderKey
+	deps   []fileStamp // files it included or tried to
+	wiki   string      // wikiPage.fingerprint, if the page has [[links]]
+	source []byte      // with includes expanded
+	page   *renderedMarkdown
+	size   int64 // bytes held
+}
+
+// fileStamp is the size and modification time a file had, or that it
+// was missing.
+type fileStamp struct {
+	path    string
+	size    int64
+	mod     int64
+	missing bool
+}
+
+func stampFile(p string) fileStamp {
+	info, err := os.Stat(p)
+	if err != nil {
+		return fileStamp{path: p, missing: true}
+	}
+	return fileStamp{p, info.Size(), info.ModTime().UnixNano(), false}
+}
+
+func newRenderCache(maxBytes int64) *renderCache {
+	return &renderCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[renderKey]*list.Element),
+	}
+}
+
+// renderedPages is the render cache, sized by -render-cache.
+var renderedPages = newRenderCache(64 << 20)
+
+// cssVersion identifies the custom CSS in use, so that a change to it is
+// never served alongside pages rendered before it.
+func cssVersion() string {
+	h := fnv.New64a()
+	h.Write([]byte(customCSS))
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// renderFile reads the markdown file rel (slash-separated) in the tree at
+// root, expands its includes, and renders it as the page at, reusing an
+// earlier rendering if nothing it depends on has changed.
+func renderFile(root, rel string, at *wikiPage) (*renderEntry, error) {
+	p := filepath.Join(root, filepath.FromSlash(rel))
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	key := renderKey{abs, absRoot, info.Size(), info.ModTime().UnixNano(), cssVersion()}
+	if e, ok := renderedPages.get(key, at); ok {
+		return e, nil
+	}
+
+	content, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	source, included := expandIncludes(root, rel, content)
+	page, err := renderMarkdownAt(source, at)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &renderEntry{key: key, source: source, page: page}
+	for _, dep := range included {
+		e.deps = append(e.deps, stampFile(filepath.Join(root, filepath.FromSlash(dep))))
+	}
+	if bytes.Contains(source, []byte("[[")) {
+		e.wiki = at.fingerprint()
+	}
+	renderedPages.put(e)
+	return e, nil
+}
+
+// modTime returns when the page's source or anything it includes was last
+// modified.
+func (e *renderEntry) modTime() time.Time {
+	mod := e.key.mod
+	for _, dep := range e.deps {
+		mod = max(mod, dep.mod)
+	}
+	return time.Unix(0, mod)
+}
+
+// get returns the entry for key if the files it included and the pages its
+// wiki links resolved among are unchanged.
+func (c *renderCache) get(key renderKey, at *wikiPage) (*renderEntry, bool) {
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+	e := el.Value.(*renderEntry)
+	c.mu.Unlock()
+
+	// Check the entry's other inputs without holding the lock.
+	fresh := e.wiki == "" || e.wiki == at.fingerprint()
+	for _, dep := range e.deps {
+		if !fresh || stampFile(dep.path) != dep {
+			fresh = false
+			break
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !fresh {
+		c.misses++
+		c.remove(key)
+		return nil, false
+	}
+	c.hits++
+	if el, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(el)
+	}
+	return e, true
+}
+
+func (c *renderCache) put(e *renderEntry) {
+	e.size = int64(len(e.source) + len(e.page.Content) + len(e.page.TOC))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e.size > c.maxBytes/4 {
+		return // too big to be worth evicting everything else for
+	}
+	c.remove(e.key)
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.bytes += e.size
+	for c.bytes > c.maxBytes {
+		c.remove(c.lru.Back().Value.(*renderEntry).key)
+		c.evictions++
+	}
+}
+
+// remove drops the entry for key, if any. The caller holds c.mu.
+func (c *renderCache) remove(key renderKey) {
+	el, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	c.lru.Remove(el)
+	delete(c.entries, key)
+	c.bytes -= el.Value.(*renderEntry).size
+}
+
+// renderCacheStats is a snapshot of a renderCache's counters.
+type renderCacheStats struct {
+	Entries   int   `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+	MaxBytes  int64 `json:"max_bytes"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+}
+
+func (c *renderCache) stats() renderCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return renderCacheStats{len(c.entries), c.bytes, c.maxBytes, c.hits, c.misses, c.evictions}
+}
+
+// serveRenderCacheStats serves the render cache's counters at
+// renderCacheStatsPath.
+func serveRenderCacheStats(w http.ResponseWriter, r *http.Request, path string) bool {
+	if path != renderCacheStatsPath {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(renderedPages.stats())
+	return true
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useRenderCache(t *testing.T, c *renderCache) {
+	old := renderedPages
+	renderedPages = c
+	t.Cleanup(func() { renderedPages = old })
+}
+
+func TestRenderFileCache(t *testing.T) {
+	useRenderCache(t, newRenderCache(1<<20))
+	dir := t.TempDir()
+	mod := time.Now().Add(-time.Hour)
+	writeFile(t, dir, "a.md", "# A\n\n<!-- include: b.md -->\n\nSee [[c]].\n", mod)
+	writeFile(t, dir, "b.md", "Part one.\n", mod)
+
+	at := &wikiPage{rel: "a.md", pages: []string{"a.md", "b.md"}}
+	render := func() string {
+		t.Helper()
+		e, err := renderFile(dir, "a.md", at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(e.page.Content)
+	}
+	render()
+	if got := render(); !strings.Contains(got, "Part one.") {
+		t.Errorf("cached page = %s", got)
+	}
+	if s := renderedPages.stats(); s.Hits != 1 || s.Misses != 1 || s.Entries != 1 {
+		t.Errorf("stats = %+v, want 1 hit, 1 miss", s)
+	}
+
+	// Changing an included file, or the pages [[links]] resolve among,
+	// renders the page again.
+	writeFile(t, dir, "b.md", "Part two.\n", mod.Add(time.Minute))
+	if got := render(); !strings.Contains(got, "Part two.") {
+		t.Errorf("stale include: %s", got)
+	}
+	at = &wikiPage{rel: "a.md", pages: []string{"a.md", "b.md", "c.md"}}
+	if got := render(); !strings.Contains(got, `<a class="wikilink" href="c.md">`) {
+		t.Errorf("stale wiki link: %s", got)
+	}
+
+	// So does changing the custom CSS.
+	old := customCSS
+	customCSS = "body { color: red }"
+	t.Cleanup(func() { customCSS = old })
+	render()
+	if s := renderedPages.stats(); s.Hits != 1 || s.Misses != 4 {
+		t.Errorf("stats = %+v, want 1 hit, 4 misses", s)
+	}
+
+	// A page including a file that doesn't exist yet is rendered again
+	// once it does.
+	writeFile(t, dir, "d.md", "<!-- include: x.md -->\n", mod)
+	renderD := func() string {
+		t.Helper()
+		e, err := renderFile(dir, "d.md", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(e.page.Content)
+	}
+	if got := renderD(); !strings.Contains(got, "not found") {
+		t.Errorf("missing include: %s", got)
+	}
+	writeFile(t, dir, "x.md", "Now here.\n", mod)
+	if got := renderD(); !strings.Contains(got, "Now here.") {
+		t.Errorf("include stayed missing after the file appeared: %s", got)
+	}
+
+	// A page rendered under two roots, as exports and the live server do,
+	// resolves includes starting with "/" against each.
+	writeFile(t, dir, "part.md", "Top part.\n", mod)
+	writeFile(t, dir, "docs/part.md", "Docs part.\n", mod)
+	writeFile(t, dir, "docs/page.md", "<!-- include: /part.md -->\n", mod)
+	for _, tc := range []struct{ root, rel, want string }{
+		{filepath.Join(dir, "docs"), "page.md", "Docs part."},
+		{dir, "docs/page.md", "Top part."},
+		{filepath.Join(dir, "docs"), "page.md", "Docs part."},
+	} {
+		e, err := renderFile(tc.root, tc.rel, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(e.page.Content); !strings.Contains(got, tc.want) {
+			t.Errorf("renderFile(%s, %s) = %s, want %s", tc.root, tc.rel, got, tc.want)
+		}
+	}
+}
+
+func TestRenderCacheEviction(t *testing.T) {
+	c := newRenderCache(400)
+	key := func(i int) renderKey { return renderKey{path: "/p" + strconv.Itoa(i)} }
+	put := func(i int) {
+		c.put(&renderEntry{key: key(i), source: make([]byte, 100), page: &renderedMarkdown{}})
+	}
+	for i := range 4 {
+		put(i)
+	}
+	if _, ok := c.get(key(0), nil); !ok {
+		t.Fatal("entry 0 missing")
+	}
+	put(4) // evicts 1, the least recently used
+	if _, ok := c.get(key(1), nil); ok {
+		t.Error("entry 1 not evicted")
+	}
+	for _, i := range []int{0, 2, 3, 4} {
+		if _, ok := c.get(key(i), nil); !ok {
+			t.Errorf("entry %d evicted", i)
+		}
+	}
+	if s := c.stats(); s.Bytes != 400 || s.Evictions != 1 {
+		t.Errorf("stats = %+v", s)
+	}
+
+	// Entries too big for the cache aren't kept.
+	c.put(&renderEntry{key: key(5), source: make([]byte, 200), page: &renderedMarkdown{}})
+	if s := c.stats(); s.Entries != 4 {
+		t.Errorf("oversized entry cached: %+v", s)
+	}
+}
+
+func TestServeRenderCacheStats(t *testing.T) {
+	useRenderCache(t, newRenderCache(1<<20))
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "a.md", "# A\n", time.Time{})
+	for range 3 {
+		serveMarkdown(httptest.NewRecorder(), httptest.NewRequest("GET", "/a.md", nil), "/a.md")
+	}
+
+	rec := httptest.NewRecorder()
+	if !serveRenderCacheStats(rec, httptest.NewRequest("GET", renderCacheStatsPath, nil), renderCacheStatsPath) {
+		t.Fatal("serveRenderCacheStats returned false")
+	}
+	var s renderCacheStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Hits != 2 || s.Misses != 1 || s.Entries != 1 || s.MaxBytes != 1<<20 {
+		t.Errorf("stats = %+v", s)
+	}
+}
@@ -0,0 +1,462 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"html"
+	"html/template"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+const (
+	searchMaxFileSize = 1 << 20 // larger files are assumed to be logs or data
+	searchMaxResults  = 50
+	searchSnippetLen  = 200 // bytes of context shown around the first match
+)
+
+// searchExts lists the extensions of files searched as text.
+var searchExts = map[string]bool{
+	".md":       true,
+	".markdown": true,
+	".txt":      true,
+	".text":     true,
+	".rst":      true,
+	".adoc":     true,
+	".org":      true,
+}
+
+// searchDoc is one indexed file.
+type searchDoc struct {
+	Path  string // slash-separated, relative to the served root
+	Title string
+	Text  string // plain text with whitespace collapsed, for snippets
+
+	size  int64
+	mod   time.Time
+	terms map[string]int // term -> occurrences, to undo postings on update
+}
+
+// searchIndex is an inverted index of the searchable files under the
+// working directory. Each search first brings the part of the index it
+// covers up to date, re-reading the directories the file watcher saw change
+// there since, and of those only the files whose size or modification time
+// changed.
+type searchIndex struct {
+	mu       sync.Mutex
+	root     string // absolute directory the index describes
+	changes  *treeChanges
+	docs     map[string]*searchDoc
+	postings map[string]map[*searchDoc]int
+	terms    []string // the keys of postings in order, for prefix matches; nil until needed
+}
+
+var siteSearch = new(searchIndex)
+
+// refresh syncs the index with the files on disk under the directory
+// under (slash-separated, "." for the root). The caller holds x.mu.
+func (x *searchIndex) refresh(under string) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if x.root != wd || x.docs == nil {
+		x.root = wd
+		x.changes = watchTree(wd)
+		x.docs = make(map[string]*searchDoc)
+		x.postings = make(map[string]map[*searchDoc]int)
+		x.terms = nil
+	}
+	changed := x.changes.take(under)
+	if len(changed) == 0 {
+		return nil
+	}
+	syncChanged(changed, slices.Collect(maps.Keys(x.docs)), func(rel string, d fs.DirEntry) {
+		if !searchExts[strings.ToLower(path.Ext(rel))] {
+			return
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > searchMaxFileSize {
+			x.remove(rel)
+			return
+		}
+		if doc := x.docs[rel]; doc != nil && doc.size == info.Size() && doc.mod.Equal(info.ModTime()) {
+			return
+		}
+		x.remove(rel)
+		if content, err := os.ReadFile(filepath.FromSlash(rel)); err == nil {
+			x.add(newSearchDoc(rel, content, info))
+		}
+	}, x.remove)
+	return nil
+}
+
+func (x *searchIndex) add(doc *searchDoc) {
+	x.docs[doc.Path] = doc
+	for term, n := range doc.terms {
+		if x.postings[term] == nil {
+			x.postings[term] = make(map[*searchDoc]int)
+			x.terms = nil
+		}
+		x.postings[term][doc] = n
+	}
+}
+
+func (x *searchIndex) remove(rel string) {
+	doc := x.docs[rel]
+	if doc == nil {
+		return
+	}
+	for term := range doc.terms {
+		delete(x.postings[term], doc)
+		if len(x.postings[term]) == 0 {
+			delete(x.postings, term)
+			x.terms = nil
+		}
+	}
+	delete(x.docs, rel)
+}
+
+func newSearchDoc(rel string, content []byte, info fs.FileInfo) *searchDoc {
+	title, body := path.Base(rel), string(content)
+	if strings.EqualFold(filepath.Ext(rel), ".md") || strings.EqualFold(filepath.Ext(rel), ".markdown") {
+		var t string
+		t, body = markdownText(content)
+		if t != "" {
+			title = t
+		}
+	}
+	doc := &searchDoc{
+		Path:  rel,
+		Title: title,
+		Text:  strings.Join(strings.Fields(body), " "),
+		size:  info.Size(),
+		mod:   info.ModTime(),
+		terms: make(map[string]int),
+	}
+	for _, t := range searchTerms(title + " " + body) {
+		doc.terms[t]++
+	}
+	return doc
+}
+
+// markdownText returns a markdown document's title (from front matter or
+// its first h1) and its text without markup.
+func markdownText(source []byte) (title, body string) {
+	pc := parser.NewContext()
+	doc := md.Parser().Parse(text.NewReader(source), parser.WithContext(pc))
+	title = pageMetaFrom(pc).Title
+	var b strings.Builder
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			if n.Type() == ast.TypeBlock {
+				b.WriteByte('\n')
+			}
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.HTMLBlock, *ast.RawHTML:
+			return ast.WalkSkipChildren, nil
+		case *ast.Heading:
+			if title == "" && n.Level == 1 {
+				title = headingText(n, source)
+			}
+		case *ast.Text:
+			b.Write(n.Segment.Value(source))
+			if n.SoftLineBreak() || n.HardLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(n.Value)
+		case *ast.AutoLink:
+			b.Write(n.Label(source))
+		case *mathInline:
+			b.Write(n.Value)
+		default:
+			if n.Type() == ast.TypeBlock && n.IsRaw() {
+				lines := n.Lines()
+				for i := 0; i < lines.Len(); i++ {
+					seg := lines.At(i)
+					b.Write(seg.Value(source))
+				}
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return title, b.String()
+}
+
+// searchTerms splits s into lowercase words.
+func searchTerms(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+type searchResult struct {
+	Path    string        `json:"path"`
+	URL     string        `json:"url"`
+	Title   string        `json:"title"`
+	Snippet template.HTML `json:"snippet"` // text around the first match, with matches in <mark>
+	score   int
+}
+
+// search returns the documents under the directory under (slash-separated,
+// "" for the root) containing every word of query, best matches first.
+// Each word also matches longer words it is a prefix of. If visible isn't
+// nil, only documents whose paths it reports true for are returned.
+func (x *searchIndex) search(query, under string, visible func(rel string) bool) ([]searchResult, error) {
+	words := searchTerms(query)
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err := x.refresh(cmp.Or(under, ".")); err != nil {
+		return nil, err
+	}
+	if x.terms == nil {
+		x.terms = slices.Sorted(maps.Keys(x.postings))
+	}
+
+	var scores map[*searchDoc]int
+	for _, w := range words {
+		matched := make(map[*searchDoc]int)
+		i, _ := slices.BinarySearch(x.terms, w)
+		for ; i < len(x.terms) && strings.HasPrefix(x.terms[i], w); i++ {
+			term := x.terms[i]
+			for doc, n := range x.postings[term] {
+				if term == w {
+					n *= 2
+				}
+				matched[doc] += n
+			}
+		}
+		if scores == nil {
+			scores = matched
+			continue
+		}
+		for doc := range scores {
+			if n, ok := matched[doc]; ok {
+				scores[doc] += n
+			} else {
+				delete(scores, doc)
+			}
+		}
+	}
+
+	re := searchHighlighter(words)
+	var results []searchResult
+	for doc, score := range scores {
+		if under != "" && !strings.HasPrefix(doc.Path, under+"/") {
+			continue
+		}
+		if visible != nil && !visible(doc.Path) {
+			continue
+		}
+		if re.MatchString(doc.Title) {
+			score += 10
+		}
+		results = append(results, searchResult{
+			Path:    doc.Path,
+			URL:     (&url.URL{Path: "/" + doc.Path}).EscapedPath(),
+			Title:   doc.Title,
+			Snippet: searchSnippet(doc.Text, re),
+			score:   score,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].Path < results[j].Path
+	})
+	if len(results) > searchMaxResults {
+		results = results[:searchMaxResults]
+	}
+	return results, nil
+}
+
+// searchHighlighter matches the start of words beginning with any of words,
+// capturing the matched part in group 1.
+func searchHighlighter(words []string) *regexp.Regexp {
+	alts := make([]string, len(words))
+	for i, w := range words {
+		alts[i] = regexp.QuoteMeta(w)
+	}
+	return regexp.MustCompile(`(?i)(?:^|[^\pL\pN])(` + strings.Join(alts, "|") + `)`)
+}
+
+// searchSnippet returns an HTML excerpt of text around the first match of
+// re, with every match highlighted.
+func searchSnippet(text string, re *regexp.Regexp) template.HTML {
+	start := 0
+	if m := re.FindStringSubmatchIndex(text); m != nil {
+		start = max(0, m[2]-searchSnippetLen/3)
+	}
+	end := min(len(text), start+searchSnippetLen)
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+	s := text[start:end]
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	last := 0
+	for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+		b.WriteString(html.EscapeString(s[last:m[2]]))
+		b.WriteString("<mark>" + html.EscapeString(s[m[2]:m[3]]) + "</mark>")
+		last = m[3]
+	}
+	b.WriteString(html.EscapeString(s[last:]))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return template.HTML(b.String())
+}
+
+// serveSearch answers ?search=query on a directory with the matching files
+// under it.
+func serveSearch(w http.ResponseWriter, r *http.Request, urlPath string) bool {
+	if !strings.HasSuffix(urlPath, "/") {
+		return false
+	}
+	under := filepath.Clean(strings.TrimPrefix(urlPath, "/"))
+	if strings.HasPrefix(under, "..") {
+		return false
+	}
+	if under == "." {
+		under = ""
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("search"))
+	results, err := siteSearch.search(query, filepath.ToSlash(under), func(rel string) bool { return accessAllowed(r, "/"+rel) })
+	if err != nil {
+		http.Error(w, "search failed: "+err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	if results == nil {
+		results = []searchResult{}
+	}
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Query   string         `json:"query"`
+			Results []searchResult `json:"results"`
+		}{query, results})
+		return true
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	searchTemplate.Execute(w, struct {
+		Title     string
+		BaseCSS   template.CSS
+		CustomCSS template.CSS
+		Action    string
+		Query     string
+		Results   []searchResult
+	}{
+		Title:     "Search",
+		BaseCSS:   template.CSS(markdownCSS),
+		CustomCSS: template.CSS(customCSS),
+		Action:    urlPath,
+		Query:     query,
+		Results:   results,
+	})
+	return true
+}
+
+var searchTemplate = template.Must(template.New("search").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{with .Query}}{{.}} - {{end}}{{.Title}}</title>
+<style>
+{{.BaseCSS}}
+.markdown-body {
+	box-sizing: border-box;
+	min-width: 200px;
+	max-width: 980px;
+	margin: 0 auto;
+	padding: 45px;
+}
+@media (max-width: 767px) {
+	.markdown-body { padding: 15px; }
+}
+.controls {
+	float: right;
+	font-size: 14px;
+}
+.controls a {
+	color: var(--fgColor-muted, #656d76);
+	margin-left: 16px;
+}
+.search input {
+	box-sizing: border-box;
+	width: 100%;
+	font: inherit;
+	padding: 6px 12px;
+	border: 1px solid var(--borderColor-default, #d1d9e0);
+	border-radius: 6px;
+	background: transparent;
+	color: inherit;
+}
+ol.results {
+	list-style: none;
+	padding-left: 0;
+}
+ol.results li {
+	margin-top: 16px;
+}
+ol.results .path {
+	color: var(--fgColor-muted, #656d76);
+	font-size: 12px;
+	margin-left: 8px;
+}
+ol.results p {
+	margin: 4px 0 0;
+}
+{{.CustomCSS}}
+</style>
+</head>
+<body class="markdown-body">
+<div class="controls"><a href="/">Browse</a></div>
+<h1>{{.Title}}</h1>
+<form class="search" action="{{.Action}}">
+<input type="search" name="search" value="{{.Query}}" placeholder="Search files" aria-label="Search" autofocus>
+</form>
+{{if .Query}}{{with .Results}}<ol class="results">
+{{range .}}<li><a href="{{.URL}}">{{.Title}}</a><span class="path">{{.Path}}</span><p>{{.Snippet}}</p></li>
+{{end}}</ol>
+{{else}}<p>No matches.</p>
+{{end}}{{end}}</body>
+</html>
+`))
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchIndex(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "README.md", "---\ntitle: Home Page\n---\n# Welcome\n\nDeploying the **service** is easy.\n", time.Time{})
+	writeFile(t, dir, "docs/deploy.md", "# Deployment\n\nRun `make deploy` to ship the service.\n", time.Time{})
+	writeFile(t, dir, "notes.txt", "service restart checklist\n", time.Time{})
+	writeFile(t, dir, "image.png", "service", time.Time{})
+	writeFile(t, dir, ".git/notes.md", "service", time.Time{})
+	writeFile(t, dir, ".serve/notes.md", "service", time.Time{})
+
+	x := new(searchIndex)
+	results, err := x.search("service", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, r := range results {
+		paths = append(paths, r.Path)
+	}
+	if got, want := strings.Join(paths, " "), "README.md docs/deploy.md notes.txt"; got != want {
+		t.Errorf("results = %s, want %s", got, want)
+	}
+
+	// Every word must match, and words match as prefixes.
+	results, _ = x.search("deploy serv", "", nil)
+	if len(results) != 2 || results[0].Title != "Deployment" {
+		t.Errorf("prefix AND query: %+v", results)
+	}
+	if results, _ := x.search("service", "docs", nil); len(results) != 1 || results[0].Path != "docs/deploy.md" {
+		t.Errorf("scoped search: %+v", results)
+	}
+	if results[0].Title != "Deployment" || results[1].Title != "Home Page" {
+		t.Errorf("titles from h1 and front matter: %+v", results)
+	}
+
+	// Edits, additions and deletions are picked up once the watcher sees
+	// them.
+	writeFile(t, dir, "notes.txt", "nothing here\n", time.Now().Add(time.Minute))
+	writeFile(t, dir, "docs/new.md", "Another service.\n", time.Time{})
+	if err := os.Remove("README.md"); err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	want := "docs/deploy.md docs/new.md"
+	eventually(t, func() bool {
+		results, _ = x.search("service", "", nil)
+		paths = paths[:0]
+		for _, r := range results {
+			paths = append(paths, r.Path)
+		}
+		got = strings.Join(paths, " ")
+		return got == want
+	})
+	if got != want {
+		t.Errorf("after changes results = %s, want %s", got, want)
+	}
+
+	// Hidden documents don't take up result slots, however well they match.
+	for i := range searchMaxResults {
+		writeFile(t, dir, fmt.Sprintf("hidden/%d.md", i), "# Service\n\nservice service\n", time.Time{})
+	}
+	eventually(t, func() bool {
+		results, _ = x.search("service", "hidden", nil)
+		return len(results) == searchMaxResults
+	})
+	results, _ = x.search("service", "", func(rel string) bool { return !strings.HasPrefix(rel, "hidden/") })
+	if len(results) != 2 || results[0].Path != "docs/deploy.md" {
+		t.Errorf("results beside hidden matches: %+v", results)
+	}
+}
+
+func TestSearchSnippet(t *testing.T) {
+	re := searchHighlighter([]string{"serv"})
+	text := strings.Repeat("lorem ", 40) + "the Service <b> observes services" + strings.Repeat(" ipsum", 40)
+	got := string(searchSnippet(text, re))
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") {
+		t.Errorf("snippet should be elided on both sides: %s", got)
+	}
+	if !strings.Contains(got, "the <mark>Serv</mark>ice &lt;b&gt; observes <mark>serv</mark>ices") {
+		t.Errorf("matches not highlighted or text not escaped: %s", got)
+	}
+	if regexp.MustCompile(`ob<mark>`).MatchString(got) {
+		t.Errorf("matched inside a word: %s", got)
+	}
+}
+
+func TestServeSearch(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "a b.md", "# Alpha\n\nFind the needle here.\n", time.Time{})
+
+	rec := httptest.NewRecorder()
+	if !serveSearch(rec, httptest.NewRequest("GET", "/?search=needle", nil), "/") {
+		t.Fatal("serveSearch returned false")
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `<a href="/a%20b.md">Alpha</a>`) || !strings.Contains(body, "<mark>needle</mark>") {
+		t.Errorf("HTML results missing link or highlight: %s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/?search=needle", nil)
+	req.Header.Set("Accept", "application/json")
+	serveSearch(rec, req, "/")
+	var resp struct {
+		Query   string
+		Results []struct{ Path, URL, Title, Snippet string }
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("bad JSON: %v: %s", err, rec.Body.String())
+	}
+	if resp.Query != "needle" || len(resp.Results) != 1 || resp.Results[0].URL != "/a%20b.md" ||
+		!strings.Contains(resp.Results[0].Snippet, "<mark>needle</mark>") {
+		t.Errorf("unexpected JSON: %+v", resp)
+	}
+
+	rec = httptest.NewRecorder()
+	serveSearch(rec, httptest.NewRequest("GET", "/?search=nothing&format=json", nil), "/")
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"query":"nothing","results":[]}` {
+		t.Errorf("empty JSON results = %s", got)
+	}
+}
@@ -14,18 +14,18 @@ import (
 	"flag"
 	"html/template"
 	"io"
-	"io/fs"
 	"log"
 	"mime"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -39,7 +39,7 @@ import (
 	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/text"
 	"github.com/yuin/goldmark/util"
-	"go.abhg.dev/goldmark/mermaid"
+	"go.abhg.dev/goldmark/frontmatter"
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/tsnet"
 )
@@ -51,16 +51,71 @@ var (
 	port     = flag.String("port", "8080", "port to listen on (local mode only)")
 	hostname = flag.String("hostname", "", "hostname to use on tailnet")
 	dataDir  = flag.String("dir", "./.serve", "directory to store tailscale state")
-	local    = flag.Bool("local", false, "run in local mode")
-	ts       = flag.Bool("ts", false, "run in Tailscale mode")
+	local    = flag.Bool("local", false, "run in local mode (with -ts, serve on localhost too)")
+	ts       = flag.Bool("ts", false, "run in Tailscale mode (with -local, serve on localhost too)")
 	proxy    = flag.String("proxy", "", "proxy requests to this URL (e.g. http://127.0.0.1:8000)")
 	index    = flag.String("index", "README.md", "default file to serve for directories (empty to disable)")
+
+	funnel          = flag.Bool("funnel", false, "also share on the public internet with Tailscale Funnel, to people with a link (implies -ts)")
+	funnelTTL       = flag.Duration("funnel-ttl", 24*time.Hour, "how long -funnel share links work (0 for no expiry)")
+	forwardIdentity = flag.Bool("identity-headers", false, "tell proxied upstreams who's asking with Tailscale-User-* headers (Tailscale mode)")
+	routeSpecs      = routesFlag("route", "proxy a path prefix to a URL, as /api/=http://127.0.0.1:8000 (add ,strip to remove the prefix); repeatable")
+	renderCacheMB   = flag.Int("render-cache", 64, "MiB of memory for caching rendered markdown (0 to disable)")
+	precompress     = flag.Bool("precompressed", false, "serve static files from .br or .gz copies beside them when the client accepts it")
 )
 
 var md = goldmark.New(
-	goldmark.WithExtensions(extension.GFM, &mermaid.Extender{}, githubHeadingIDs{}),
+	goldmark.WithExtensions(
+		extension.GFM,
+		&frontmatter.Extender{},
+		diagrams,
+		syntaxHighlighting,
+		mathExtension{},
+		githubHeadingIDs{},
+		githubAlerts{},
+		tableOfContents{},
+		wikiLinks{},
+	),
 )
 
+// renderedMarkdown is a markdown document converted to HTML, along with what
+// was collected about it while parsing.
+type renderedMarkdown struct {
+	Content template.HTML
+	TOC     template.HTML // sidebar table of contents; empty if not wanted
+	Meta    pageMeta
+}
+
+// title returns the front matter title, or fallback if there isn't one.
+func (p *renderedMarkdown) title(fallback string) string {
+	if p.Meta.Title != "" {
+		return p.Meta.Title
+	}
+	return fallback
+}
+
+func renderMarkdown(source []byte) (*renderedMarkdown, error) {
+	return renderMarkdownAt(source, nil)
+}
+
+// renderMarkdownAt renders source as the page at, resolving its [[links]]
+// among the other pages of its tree.
+func renderMarkdownAt(source []byte, at *wikiPage) (*renderedMarkdown, error) {
+	pc := parser.NewContext()
+	if at != nil {
+		pc.Set(wikiPageKey, at)
+	}
+	var buf bytes.Buffer
+	if err := md.Convert(source, &buf, parser.WithContext(pc)); err != nil {
+		return nil, err
+	}
+	return &renderedMarkdown{
+		Content: template.HTML(buf.String()),
+		TOC:     sidebarTOC(pc),
+		Meta:    pageMetaFrom(pc),
+	}, nil
+}
+
 type githubHeadingIDs struct{}
 
 func (githubHeadingIDs) Extend(m goldmark.Markdown) {
@@ -138,7 +193,28 @@ func githubSlug(s string) string {
 	return b.String()
 }
 
-var mdTemplate = template.Must(template.New("markdown").Parse(`<!DOCTYPE html>
+// liveReloadScript defines the "live-reload" template that rendered pages
+// and listings end with. Given the URL of the page's change events, it
+// reloads the page when one arrives.
+const liveReloadScript = `{{define "live-reload"}}<script>
+// Live reload: re-render when the source changes, keeping the scroll position.
+(function () {
+	var key = "serve-scroll:" + location.pathname + location.search;
+	var y = sessionStorage.getItem(key);
+	if (y !== null) {
+		sessionStorage.removeItem(key);
+		window.scrollTo(0, +y);
+	}
+	if (!window.EventSource) return;
+	new EventSource({{.}}).addEventListener("change", function () {
+		sessionStorage.setItem(key, window.scrollY);
+		location.reload();
+	});
+})();
+</script>
+{{end}}`
+
+var mdTemplate = template.Must(template.Must(template.New("markdown").Parse(`<!DOCTYPE html>
 <html>
 <head>
 <meta charset="utf-8">
@@ -164,6 +240,88 @@ var mdTemplate = template.Must(template.New("markdown").Parse(`<!DOCTYPE html>
 	color: var(--fgColor-muted, #656d76);
 	margin-left: 16px;
 }
+.controls form {
+	display: inline;
+	margin-left: 16px;
+}
+.controls input {
+	width: 160px;
+	font: inherit;
+	padding: 2px 8px;
+	border: 1px solid var(--borderColor-default, #d1d9e0);
+	border-radius: 6px;
+	background: transparent;
+	color: inherit;
+}
+.toc {
+	font-size: 14px;
+	padding: 8px 16px;
+	margin-bottom: 16px;
+	border: 1px solid var(--borderColor-default, #d1d9e0);
+	border-radius: 6px;
+}
+.markdown-body .toc ul {
+	list-style: none;
+	margin: 0;
+	padding-left: 16px;
+}
+.markdown-body .toc > ul {
+	padding-left: 0;
+}
+.markdown-body .toc li + li {
+	margin-top: 2px;
+}
+.markdown-body math[display="block"] {
+	margin: 16px 0;
+	overflow-x: auto;
+}
+.page-meta {
+	color: var(--fgColor-muted, #656d76);
+	font-size: 14px;
+	margin-bottom: 16px;
+}
+.page-meta > * + * {
+	margin-left: 12px;
+}
+.page-meta .tag {
+	display: inline-block;
+	padding: 0 8px;
+	margin-right: 4px;
+	border-radius: 2em;
+	background-color: var(--bgColor-neutral-muted, #818b981f);
+}
+@media (min-width: 1500px) {
+	body > .toc {
+		position: fixed;
+		top: 45px;
+		left: calc(50% + 514px);
+		width: 220px;
+		max-height: calc(100vh - 90px);
+		overflow-y: auto;
+		margin: 0;
+	}
+}
+.markdown-body .wikilink.missing {
+	color: var(--fgColor-danger, #d1242f);
+	text-decoration: underline dotted;
+	cursor: help;
+}
+.backlinks {
+	font-size: 14px;
+	margin-top: 32px;
+	padding-top: 16px;
+	border-top: 1px solid var(--borderColor-default, #d1d9e0);
+}
+.markdown-body .backlinks h2 {
+	font-size: 14px;
+	border: 0;
+	margin: 0 0 8px;
+	padding: 0;
+	color: var(--fgColor-muted, #656d76);
+}
+.markdown-body .backlinks ul {
+	margin: 0;
+}
 {{.CustomCSS}}
 </style>
 </head>
@@ -173,11 +331,25 @@ var mdTemplate = template.Must(template.New("markdown").Parse(`<!DOCTYPE html>
 <a href="?raw">View raw</a>
 <a href="?download">Download HTML</a>
 <a href="{{.ExportPath}}">Export folder</a>
+<form action="/"><input type="search" name="search" placeholder="Search" aria-label="Search"></form>
 </div>
-{{.Content}}
-</body>
+{{with .Meta}}{{if or .Author .Date .Tags}}<header class="page-meta">
+{{- with .Author}}<span class="author">{{.}}</span>{{end}}
+{{- with .Date}}<time>{{.}}</time>{{end}}
+{{- with .Tags}}<span class="tags">{{range .}}<span class="tag">{{.}}</span>{{end}}</span>{{end -}}
+</header>
+{{end}}{{end}}{{with .TOC}}<nav class="toc">
+{{.}}</nav>
+{{end}}{{.Content}}
+{{with .Backlinks}}<aside class="backlinks">
+<h2>Linked from</h2>
+<ul>
+{{range .}}<li><a href="{{.URL}}">{{.Title}}</a></li>
+{{end}}</ul>
+</aside>
+{{end}}{{template "live-reload" .LiveReload}}</body>
 </html>
-`))
+`)).Parse(liveReloadScript))
 
 var mdTemplateStandalone = template.Must(template.New("markdown-standalone").Parse(`<!DOCTYPE html>
 <html>
@@ -197,16 +369,251 @@ var mdTemplateStandalone = template.Must(template.New("markdown-standalone").Par
 @media (max-width: 767px) {
 	.markdown-body { padding: 15px; }
 }
+.toc {
+	font-size: 14px;
+	padding: 8px 16px;
+	margin-bottom: 16px;
+	border: 1px solid var(--borderColor-default, #d1d9e0);
+	border-radius: 6px;
+}
+.markdown-body .toc ul {
+	list-style: none;
+	margin: 0;
+	padding-left: 16px;
+}
+.markdown-body .toc > ul {
+	padding-left: 0;
+}
+.markdown-body .toc li + li {
+	margin-top: 2px;
+}
+.markdown-body math[display="block"] {
+	margin: 16px 0;
+	overflow-x: auto;
+}
+.page-meta {
+	color: var(--fgColor-muted, #656d76);
+	font-size: 14px;
+	margin-bottom: 16px;
+}
+.page-meta > * + * {
+	margin-left: 12px;
+}
+.page-meta .tag {
+	display: inline-block;
+	padding: 0 8px;
+	margin-right: 4px;
+	border-radius: 2em;
+	background-color: var(--bgColor-neutral-muted, #818b981f);
+}
+@media (min-width: 1500px) {
+	body > .toc {
+		position: fixed;
+		top: 45px;
+		left: calc(50% + 514px);
+		width: 220px;
+		max-height: calc(100vh - 90px);
+		overflow-y: auto;
+		margin: 0;
+	}
+}
+.export-search {
+	position: relative;
+	float: right;
+	font-size: 14px;
+}
+.export-search input {
+	width: 200px;
+	font: inherit;
+	padding: 2px 8px;
+	border: 1px solid var(--borderColor-default, #d1d9e0);
+	border-radius: 6px;
+	background: transparent;
+	color: inherit;
+}
+.export-search .results {
+	position: absolute;
+	right: 0;
+	z-index: 1;
+	width: 400px;
+	max-width: 90vw;
+	max-height: 60vh;
+	overflow-y: auto;
+	margin: 4px 0 0;
+	padding: 8px 16px;
+	list-style: none;
+	background-color: var(--bgColor-default, #fff);
+	border: 1px solid var(--borderColor-default, #d1d9e0);
+	border-radius: 6px;
+}
+.export-search .results li + li {
+	margin-top: 8px;
+}
+.export-search .results div {
+	color: var(--fgColor-muted, #656d76);
+	font-size: 12px;
+}
+.markdown-body .wikilink.missing {
+	color: var(--fgColor-danger, #d1242f);
+	text-decoration: underline dotted;
+	cursor: help;
+}
+.pager {
+	display: flex;
+	gap: 16px;
+	margin-top: 32px;
+	font-size: 14px;
+}
+.pager .next {
+	margin-left: auto;
+	text-align: right;
+}
+.site-nav {
+	font-size: 14px;
+	margin-top: 32px;
+	padding-top: 16px;
+	border-top: 1px solid var(--borderColor-default, #d1d9e0);
+}
+.markdown-body .site-nav ul {
+	list-style: none;
+	margin: 0;
+	padding-left: 16px;
+}
+.markdown-body .site-nav > ul {
+	padding-left: 0;
+}
+.site-nav a[aria-current] {
+	color: inherit;
+	font-weight: 600;
+}
+@media (min-width: 1500px) {
+	body > .site-nav {
+		position: fixed;
+		top: 45px;
+		right: calc(50% + 514px);
+		width: 220px;
+		max-height: calc(100vh - 90px);
+		overflow-y: auto;
+		margin: 0;
+		padding: 0;
+		border: 0;
+	}
+}
+.crumbs > * + *::before {
+	content: " / ";
+	color: var(--fgColor-muted, #656d76);
+}
+.markdown-body table.dir {
+	display: table;
+	width: 100%;
+}
+table.dir td.size,
+table.dir td.mtime {
+	white-space: nowrap;
+	color: var(--fgColor-muted, #656d76);
+}
+table.dir .size {
+	text-align: right;
+}
+table.dir .icon {
+	display: inline-block;
+	width: 1.5em;
+}
 {{.CustomCSS}}
 </style>
 </head>
 <body class="markdown-body">
-{{.Content}}
-</body>
+{{if .SearchRoot}}<div class="export-search">
+<input type="search" placeholder="Search" aria-label="Search">
+<ol class="results" hidden></ol>
+</div>
+{{end}}{{with .Meta}}{{if or .Author .Date .Tags}}<header class="page-meta">
+{{- with .Author}}<span class="author">{{.}}</span>{{end}}
+{{- with .Date}}<time>{{.}}</time>{{end}}
+{{- with .Tags}}<span class="tags">{{range .}}<span class="tag">{{.}}</span>{{end}}</span>{{end -}}
+</header>
+{{end}}{{end}}{{with .TOC}}<nav class="toc">
+{{.}}</nav>
+{{end}}{{.Content}}
+{{if or .Prev .Next}}<nav class="pager">
+{{- with .Prev}}<a class="prev" href="{{.Href}}">← {{.Title}}</a>{{end}}
+{{- with .Next}}<a class="next" href="{{.Href}}">{{.Title}} →</a>{{end -}}
+</nav>
+{{end}}{{with .Nav}}<nav class="site-nav" aria-label="Site">
+{{.}}</nav>
+{{end}}{{with .SearchRoot}}<script src="{{.}}search-index.js"></script>
+<script>
+// Search this export's pages with the index loaded above.
+(function () {
+	var root = {{.}};
+	var pages = window.serveSearchIndex || [];
+	var box = document.querySelector(".export-search input");
+	var list = document.querySelector(".export-search .results");
+	pages.forEach(function (p) {
+		p.lowerTitle = p.title.toLowerCase();
+		p.lowerText = p.text.toLowerCase();
+	});
+	function snippet(p, words) {
+		var div = document.createElement("div");
+		var at = -1, len = 0;
+		words.forEach(function (w) {
+			var i = p.lowerText.indexOf(w);
+			if (i >= 0 && (at < 0 || i < at)) {
+				at = i;
+				len = w.length;
+			}
+		});
+		if (at < 0) return div;
+		var start = Math.max(0, at - 60);
+		var end = Math.min(p.text.length, at + 140);
+		var mark = document.createElement("mark");
+		mark.textContent = p.text.slice(at, at + len);
+		div.append((start > 0 ? "…" : "") + p.text.slice(start, at), mark,
+			p.text.slice(at + len, end) + (end < p.text.length ? "…" : ""));
+		return div;
+	}
+	box.addEventListener("input", function () {
+		var words = box.value.toLowerCase().split(/\s+/).filter(Boolean);
+		list.textContent = "";
+		list.hidden = !words.length;
+		var hits = [];
+		pages.forEach(function (p) {
+			var score = 0;
+			for (var i = 0; i < words.length; i++) {
+				var inTitle = p.lowerTitle.indexOf(words[i]) >= 0;
+				if (!inTitle && p.lowerText.indexOf(words[i]) < 0) return;
+				score += inTitle ? 10 : 1;
+			}
+			if (words.length) hits.push({page: p, score: score});
+		});
+		hits.sort(function (a, b) { return b.score - a.score; });
+		hits.slice(0, 20).forEach(function (h) {
+			var li = document.createElement("li");
+			var a = document.createElement("a");
+			a.href = root + h.page.url;
+			a.textContent = h.page.title;
+			li.append(a, snippet(h.page, words));
+			list.append(li);
+		});
+		if (words.length && !hits.length) {
+			list.innerHTML = "<li>No matches.</li>";
+		}
+	});
+	box.addEventListener("keydown", function (e) {
+		var first = list.querySelector("a");
+		if (e.key === "Enter" && first) location.href = first.href;
+		if (e.key === "Escape") {
+			box.value = "";
+			list.hidden = true;
+		}
+	});
+})();
+</script>
+{{end}}</body>
 </html>
 `))
 
-var dirListTemplate = template.Must(template.New("dirlist").Parse(`<!DOCTYPE html>
+var dirListTemplate = template.Must(template.Must(template.New("dirlist").Parse(`<!DOCTYPE html>
 <html>
 <head>
 <meta charset="utf-8">
@@ -232,30 +639,72 @@ var dirListTemplate = template.Must(template.New("dirlist").Parse(`<!DOCTYPE htm
 	color: var(--fgColor-muted, #656d76);
 	margin-left: 16px;
 }
-ul.dir {
-	list-style: none;
-	padding-left: 0;
+.controls form {
+	display: inline;
+	margin-left: 16px;
+}
+.controls input {
+	width: 160px;
+	font: inherit;
+	padding: 2px 8px;
+	border: 1px solid var(--borderColor-default, #d1d9e0);
+	border-radius: 6px;
+	background: transparent;
+	color: inherit;
+}
+.crumbs > * + *::before {
+	content: " / ";
+	color: var(--fgColor-muted, #656d76);
+}
+.markdown-body table.dir {
+	display: table;
+	width: 100%;
+}
+table.dir th a {
+	color: inherit;
+}
+table.dir td.size,
+table.dir td.mtime {
+	white-space: nowrap;
+	color: var(--fgColor-muted, #656d76);
+}
+table.dir .size {
+	text-align: right;
 }
-ul.dir li {
-	padding: 2px 0;
+table.dir .icon {
+	display: inline-block;
+	width: 1.5em;
 }
 {{.CustomCSS}}
 </style>
 </head>
 <body class="markdown-body">
-<div class="controls"><a href="?export">Download HTML zip</a></div>
-<h1>{{.Title}}</h1>
-<ul class="dir">
-{{range .Entries}}<li><a href="{{.Href}}">{{.Name}}</a></li>
-{{end}}</ul>
-</body>
+<div class="controls">
+<a href="?export">Download HTML zip</a>
+<form action="/"><input type="search" name="search" placeholder="Search" aria-label="Search"></form>
+</div>
+<h1 class="crumbs">{{range .Crumbs}}{{if .Href}}<a href="{{.Href}}">{{.Name}}</a>{{else}}<span>{{.Name}}</span>{{end}}{{end}}</h1>
+<table class="dir">
+<thead><tr>{{range .Columns}}<th class="{{.Class}}"><a href="{{.Href}}">{{.Label}}{{.Arrow}}</a></th>{{end}}</tr></thead>
+<tbody>
+{{range .Entries}}<tr><td class="name"><span class="icon" aria-hidden="true">{{.Icon}}</span><a href="{{.Href}}">{{.Name}}</a></td><td class="size">{{.SizeText}}</td><td class="mtime">{{.Modified}}</td></tr>
+{{end}}</tbody>
+</table>
+{{template "live-reload" .LiveReload}}</body>
 </html>
-`))
+`)).Parse(liveReloadScript))
 
 var customCSS string // loaded from .serve/custom.css if present
 
 func main() {
 	flag.Parse()
+	renderedPages = newRenderCache(int64(*renderCacheMB) << 20)
+	switch flag.Arg(0) {
+	case "export":
+		os.Exit(runExport(flag.Args()[1:]))
+	case "check":
+		os.Exit(runCheck(flag.Args()[1:]))
+	}
 	ensureGitignore()
 
 	// Globally filter logs to suppress tsnet noise
@@ -283,45 +732,60 @@ func main() {
 	proxyFile := filepath.Join(*dataDir, "proxy")
 	indexCfgFile := filepath.Join(*dataDir, "index")
 	portFile := filepath.Join(*dataDir, "port")
+	routesFile := filepath.Join(*dataDir, "routes")
 	if !isFlagSet("proxy") {
 		if saved, err := os.ReadFile(proxyFile); err == nil {
 			*proxy = strings.TrimSpace(string(saved))
 		}
 	}
+	if !isFlagSet("route") {
+		if saved, err := readRoutes(routesFile); err == nil {
+			*routeSpecs = saved
+		}
+	}
 	if !isFlagSet("index") {
 		if saved, err := os.ReadFile(indexCfgFile); err == nil {
 			*index = strings.TrimSpace(string(saved))
 		}
 	}
 
-	// Determine mode: local vs Tailscale
-	// Priority: -local flag > -ts flag > existing TS state > existing port config > default local
-	useLocalMode := false
+	// Determine where to serve: locally, on the tailnet, or both
+	// Priority: -local/-ts flags (both for both) > existing TS state > existing port config > default local
+	var serveLocal, serveTailnet bool
 	switch {
-	case *local:
-		useLocalMode = true
-	case *ts:
-		useLocalMode = false
+	case *local || *ts || *funnel:
+		serveLocal, serveTailnet = *local, *ts || *funnel
 	case hasTailscaleState(*dataDir):
-		useLocalMode = false
+		serveTailnet = true
 	case hasLocalConfig(*dataDir):
-		useLocalMode = true
+		serveLocal = true
 	default:
-		useLocalMode = true // Default to local mode
+		serveLocal = true // Default to local mode
 	}
 
-	var ln net.Listener
+	var lns []net.Listener
 	var whoIs func(context.Context, string) (*apitype.WhoIsResponse, error)
-	var err error
-	var listenAddr string
-	var serverURL string
 
+	routes, err := newProxyRoutes(*routeSpecs, *proxy)
+	if err != nil {
+		log.Fatal(err)
+	}
 	desc := prettyPath()
 	if *proxy != "" {
 		desc = "proxy to " + *proxy
 	}
+	if rd := routesDesc(routes); rd != "" {
+		desc += " with " + rd
+	}
+
+	if serveLocal {
+		// Alongside the tailnet, listen only on loopback so nobody on the
+		// LAN gets around the tailnet's access rules.
+		host := ""
+		if serveTailnet {
+			host = "127.0.0.1"
+		}
 
-	if useLocalMode {
 		// Load saved port if not explicitly set
 		if !isFlagSet("port") {
 			if saved, err := os.ReadFile(portFile); err == nil {
@@ -332,37 +796,35 @@ func main() {
 		// Determine if we should save port (only when explicitly set or port config exists)
 		savePort := isFlagSet("port") || hasLocalConfig(*dataDir)
 
+		var ln net.Listener
 		if !savePort {
 			// Dynamic port finding for unconfigured local mode
-			*port, ln, err = findAvailablePort()
+			*port, ln, err = findAvailablePort(host)
 			if err != nil {
 				log.Fatal(err)
 			}
 		} else {
-			listenAddr = ":" + *port
-			ln, err = net.Listen("tcp", listenAddr)
+			ln, err = net.Listen("tcp", net.JoinHostPort(host, *port))
 			if err != nil {
 				log.Fatal(err)
 			}
 		}
-		listenAddr = ":" + *port
+		defer ln.Close()
+		lns = append(lns, localListener{ln})
 
 		// Save preferences only when explicitly set
 		if isFlagSet("port") {
 			os.WriteFile(portFile, []byte(*port), 0600)
 		}
-		if isFlagSet("proxy") {
-			os.WriteFile(proxyFile, []byte(*proxy), 0600)
-		}
-		if isFlagSet("index") {
-			os.WriteFile(indexCfgFile, []byte(*index), 0600)
-		}
 
-		serverURL = "http://localhost" + listenAddr
+		serverURL := "http://localhost:" + *port
 		log.Printf("%s at %s", desc, serverURL)
-	} else {
+		openBrowser(serverURL)
+	}
+
+	if serveTailnet {
 		// Tailscale mode uses :443
-		listenAddr = ":443"
+		listenAddr := ":443"
 
 		s := &tsnet.Server{
 			Hostname: *hostname,
@@ -370,7 +832,17 @@ func main() {
 			// We rely on the global log filter to catch tsnet logs
 		}
 		defer s.Close()
-		ln, err = s.Listen("tcp", listenAddr)
+		var ln net.Listener
+		if *funnel {
+			siteFunnel, err = newFunnelGate(filepath.Join(*dataDir, "funnel-key"), *funnelTTL)
+			if err != nil {
+				log.Fatal(err)
+			}
+			// Funnel listeners terminate TLS themselves.
+			ln, err = s.ListenFunnel("tcp", listenAddr)
+		} else {
+			ln, err = s.Listen("tcp", listenAddr)
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -381,6 +853,11 @@ func main() {
 		}
 		whoIs = lc.WhoIs
 
+		siteACL, err = loadACL(filepath.Join(*dataDir, "acl"))
+		if err != nil {
+			log.Fatal(err)
+		}
+
 		go func() {
 			// Wait for the backend to be running to print the URL
 			ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
@@ -389,9 +866,20 @@ func main() {
 				st, err := lc.Status(ctx)
 				if err == nil && st.BackendState == "Running" {
 					dnsName := strings.TrimSuffix(st.Self.DNSName, ".")
-					serverURL = "https://" + dnsName
+					serverURL := "https://" + dnsName
 					log.Printf("%s at %s", desc, serverURL)
-					openBrowser(serverURL)
+					if siteFunnel != nil {
+						link, expires := siteFunnel.shareLink(serverURL)
+						until := "no expiry"
+						if !expires.IsZero() {
+							until = "until " + expires.Format(time.DateTime)
+						}
+						log.Printf("public via Funnel (%s) at %s", until, link)
+					}
+					// The local address is already open when serving both
+					if !serveLocal {
+						openBrowser(serverURL)
+					}
 					return
 				}
 				select {
@@ -403,69 +891,96 @@ func main() {
 			}
 		}()
 
-		ln = tls.NewListener(ln, &tls.Config{
-			GetCertificate: lc.GetCertificate,
-		})
-
-		// Save preferences only when explicitly set
-		if isFlagSet("proxy") {
-			os.WriteFile(proxyFile, []byte(*proxy), 0600)
-		}
-		if isFlagSet("index") {
-			os.WriteFile(indexCfgFile, []byte(*index), 0600)
+		if !*funnel {
+			ln = tls.NewListener(ln, &tls.Config{
+				GetCertificate: lc.GetCertificate,
+			})
 		}
+		defer ln.Close()
+		lns = append(lns, ln)
 	}
-	defer ln.Close()
 
-	// Open browser for local mode (Tailscale mode does it after ready)
-	if useLocalMode {
-		openBrowser(serverURL)
+	// Save preferences only when explicitly set
+	if isFlagSet("proxy") {
+		os.WriteFile(proxyFile, []byte(*proxy), 0600)
+	}
+	if isFlagSet("route") {
+		os.WriteFile(routesFile, []byte(strings.Join(*routeSpecs, "\n")), 0600)
+	}
+	if isFlagSet("index") {
+		os.WriteFile(indexCfgFile, []byte(*index), 0600)
 	}
 
 	// Serve the current directory or proxy with access logging
-	var rp *httputil.ReverseProxy
-	if *proxy != "" {
-		u, err := url.Parse(*proxy)
-		if err != nil {
-			log.Fatal(err)
-		}
-		rp = &httputil.ReverseProxy{
-			Rewrite: func(pr *httputil.ProxyRequest) {
-				pr.SetURL(u)
-				// pr.SetXForwarded() is NOT called to keep it "pure"
-				// Additionally strip any proxy headers that might have been present in the original request
-				pr.Out.Header.Del("X-Forwarded-For")
-				pr.Out.Header.Del("X-Forwarded-Host")
-				pr.Out.Header.Del("X-Forwarded-Proto")
-			},
-		}
-	}
 	fs := http.FileServer(http.Dir("."))
+
+	// Request contexts derive from baseCtx so long-lived live reload streams
+	// end when we shut down instead of holding Shutdown open.
+	baseCtx, stopRequests := context.WithCancel(context.Background())
 	srv := &http.Server{
 		ReadHeaderTimeout: 10 * time.Second,
 		IdleTimeout:       120 * time.Second,
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if useLocalMode {
+		BaseContext:       listenerContext(baseCtx),
+		ConnContext:       funnelConnContext,
+		Handler: compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Requests on the local listener have no tailnet identity
+			var who *apitype.WhoIsResponse
+			_, public := funnelSource(r)
+			onLocal := localRequest(r)
+			if !onLocal && !public {
+				if id, err := whoIs(r.Context(), r.RemoteAddr); err == nil {
+					who = id
+				}
+			}
+			r = withIdentity(r, who)
+			if hidePrivate(w, r, r.URL.Path) {
+				return
+			}
+			if public && !siteFunnel.admit(w, r) {
+				return
+			}
+			if ok, rule := aclFor(r).check(who, r.URL.Path); !ok {
+				denyAccess(w, r, rule.String())
+				return
+			}
+			if onLocal {
 				log.Print(r.URL.Path)
 			} else {
-				who, err := whoIs(r.Context(), r.RemoteAddr)
-				if err != nil {
-					log.Printf("? %s", r.URL.Path)
-				} else {
-					log.Printf("%s (%s) %s",
-						who.UserProfile.LoginName,
-						firstLabel(who.Node.ComputedName),
-						r.URL.Path)
-				}
+				log.Printf("%s %s", requesterName(r), r.URL.Path)
 			}
 
-			if rp != nil {
-				rp.ServeHTTP(w, r)
+			if rt := matchRoute(routes, r.URL.Path); rt != nil {
+				rt.rp.ServeHTTP(w, r)
 				return
 			}
 
 			path := r.URL.Path
 
+			// Serve the embedded mermaid.js that diagram pages load
+			if serveMermaidJS(w, r, path) {
+				return
+			}
+
+			// Report how well the render cache is doing
+			if serveRenderCacheStats(w, r, path) {
+				return
+			}
+
+			// Stream change events to pages that auto-reload on edit
+			if r.URL.Query().Has("livereload") && serveLiveReload(w, r, path) {
+				return
+			}
+
+			// Search markdown and text files under a directory
+			if r.URL.Query().Has("search") && serveSearch(w, r, path) {
+				return
+			}
+
+			// Report broken links in the markdown under a directory
+			if r.URL.Query().Has("linkcheck") && serveLinkCheck(w, r, path) {
+				return
+			}
+
 			// Export a directory tree as a browsable HTML+assets bundle
 			if strings.HasSuffix(path, "/") && r.URL.Query().Has("export") {
 				if serveExport(w, r, path) {
@@ -474,12 +989,7 @@ func main() {
 			}
 
 			// Serve index file for directory requests unless ?list is present
-			if strings.HasSuffix(path, "/") && *index != "" && !r.URL.Query().Has("list") {
-				indexPath := filepath.Join(".", path, *index)
-				if info, err := os.Stat(indexPath); err == nil && !info.IsDir() {
-					path = filepath.Join(path, *index)
-				}
-			}
+			path = dirIndex(r, path)
 
 			// Render markdown files as HTML unless ?raw is requested
 			if serveMarkdown(w, r, path) {
@@ -491,8 +1001,13 @@ func main() {
 			if strings.HasSuffix(path, "/") && serveDirList(w, r, path) {
 				return
 			}
+
+			// Serve a compressed copy of a static file if there is one
+			if servePrecompressed(w, r, path) {
+				return
+			}
 			fs.ServeHTTP(w, r)
-		}),
+		})),
 	}
 
 	// Graceful shutdown on interrupt
@@ -501,12 +1016,13 @@ func main() {
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 		<-sigCh
 		log.Printf("shutting down...")
+		stopRequests()
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		srv.Shutdown(ctx)
 	}()
 
-	if err := srv.Serve(ln); err != http.ErrServerClosed {
+	if err := serveAll(srv, lns); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -526,7 +1042,8 @@ func (f *logFilter) Write(p []byte) (n int, err error) {
 		msg := s[20:]
 		if msg[0] == '/' || // Local access
 			strings.HasPrefix(msg, "? ") || // Unknown user
-			strings.Contains(msg, ") /") { // Tailscale access
+			strings.Contains(msg, ") /") || // Tailscale access
+			strings.HasPrefix(msg, "denied: ") { // Refused by .serve/acl
 			return os.Stderr.Write(p)
 		}
 	}
@@ -594,16 +1111,24 @@ func hasLocalConfig(dataDir string) bool {
 	return err == nil
 }
 
-func findAvailablePort() (string, net.Listener, error) {
+// skippedDir reports whether a directory named name is left out of exports,
+// search, wiki links, link checks, and Funnel: serve's own state and version
+// control metadata.
+func skippedDir(name string) bool {
+	return name == ".serve" || name == ".git"
+}
+
+// findAvailablePort listens on host at the first free port from 8080 up.
+func findAvailablePort(host string) (string, net.Listener, error) {
 	for p := 8080; p < 9000; p++ {
-		addr := ":" + strconv.Itoa(p)
+		addr := net.JoinHostPort(host, strconv.Itoa(p))
 		ln, err := net.Listen("tcp", addr)
 		if err == nil {
 			return strconv.Itoa(p), ln, nil
 		}
 	}
 	// Let OS pick if all ports busy
-	ln, err := net.Listen("tcp", ":0")
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
 	if err != nil {
 		return "", nil, err
 	}
@@ -611,6 +1136,24 @@ func findAvailablePort() (string, net.Listener, error) {
 	return port, ln, nil
 }
 
+// dirIndex returns the path to serve for urlPath: its index file if it is
+// a directory with one the requester may see and no listing was asked for,
+// and otherwise urlPath itself.
+func dirIndex(r *http.Request, urlPath string) string {
+	if !strings.HasSuffix(urlPath, "/") || *index == "" || r.URL.Query().Has("list") || wantsJSON(r) {
+		return urlPath
+	}
+	indexPath := filepath.Join(".", urlPath, *index)
+	if info, err := os.Stat(indexPath); err != nil || info.IsDir() {
+		return urlPath
+	}
+	// A denied index falls back to the listing, which leaves it out.
+	if !accessAllowed(r, urlPath+*index) {
+		return urlPath
+	}
+	return filepath.Join(urlPath, *index)
+}
+
 func openBrowser(url string) {
 	// macOS
 	exec.Command("open", url).Start()
@@ -633,30 +1176,43 @@ func serveMarkdown(w http.ResponseWriter, r *http.Request, path string) bool {
 		return false
 	}
 
-	content, err := os.ReadFile(clean)
-	if err != nil {
+	if info, err := os.Stat(clean); err != nil || info.IsDir() {
 		return false // Let file server handle the error
 	}
 
-	var buf bytes.Buffer
-	if err := md.Convert(content, &buf); err != nil {
+	// [[Links]] and backlinks leave out pages the requester can't see.
+	at, backlinks := siteWiki.page(filepath.ToSlash(clean))
+	if aclFor(r) != nil {
+		at.pages = slices.DeleteFunc(slices.Clone(at.pages), func(p string) bool { return !accessAllowed(r, "/"+p) })
+	}
+	backlinks = slices.DeleteFunc(backlinks, func(b backlink) bool { return !accessAllowed(r, "/"+b.Path) })
+	rendered, err := renderFile(".", filepath.ToSlash(clean), at)
+	if err != nil {
 		http.Error(w, "failed to render markdown", http.StatusInternalServerError)
 		return true
 	}
+	page := rendered.page
 
 	// Handle download request
 	if r.URL.Query().Has("download") {
 		// Render standalone HTML (without controls)
 		var htmlBuf bytes.Buffer
 		err := mdTemplateStandalone.Execute(&htmlBuf, struct {
-			Title     string
-			BaseCSS   template.CSS
-			Content   template.HTML
-			CustomCSS template.CSS
+			Title      string
+			BaseCSS    template.CSS
+			Content    template.HTML
+			TOC        template.HTML
+			Meta       pageMeta
+			CustomCSS  template.CSS
+			SearchRoot string
+			Nav        template.HTML
+			Prev, Next *exportLink
 		}{
-			Title:     filepath.Base(path),
-			BaseCSS:   template.CSS(markdownCSS),
-			Content:   template.HTML(buf.String()),
+			Title:     page.title(filepath.Base(path)),
+			BaseCSS:   template.CSS(markdownCSS + highlightCSS),
+			Content:   page.Content,
+			TOC:       page.TOC,
+			Meta:      page.Meta,
 			CustomCSS: template.CSS(customCSS),
 		})
 		if err != nil {
@@ -664,8 +1220,8 @@ func serveMarkdown(w http.ResponseWriter, r *http.Request, path string) bool {
 			return true
 		}
 
-		// Embed images as data URIs
-		htmlWithImages, err := embedImages(htmlBuf.Bytes(), clean)
+		// Embed images and the diagram script so the page works offline
+		htmlWithImages, err := embedImages(inlineMermaidJS(htmlBuf.Bytes()), clean)
 		if err != nil {
 			http.Error(w, "failed to embed images", http.StatusInternalServerError)
 			return true
@@ -675,36 +1231,25 @@ func serveMarkdown(w http.ResponseWriter, r *http.Request, path string) bool {
 		htmlFilename := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + ".html"
 		zipFilename := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + ".zip"
 
-		// Create zip file in memory
-		var zipBuf bytes.Buffer
-		zipWriter := zip.NewWriter(&zipBuf)
-
-		// Add HTML file to zip, carrying the source file's mod time
+		// Stream the zip to the client, carrying the source file's mod time
 		mod := time.Time{}
 		if info, err := os.Stat(clean); err == nil {
 			mod = info.ModTime()
 		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+zipFilename+"\"")
+		sw := &streamWriter{w: w}
+		zipWriter := zip.NewWriter(sw)
 		htmlFile, err := zipEntry(zipWriter, htmlFilename, mod)
-		if err != nil {
-			http.Error(w, "failed to create zip", http.StatusInternalServerError)
-			return true
+		if err == nil {
+			_, err = io.Copy(htmlFile, contextReader{r.Context(), bytes.NewReader(htmlWithImages)})
 		}
-		if _, err := io.Copy(htmlFile, bytes.NewReader(htmlWithImages)); err != nil {
-			http.Error(w, "failed to write HTML to zip", http.StatusInternalServerError)
-			return true
+		if err == nil {
+			err = zipWriter.Close()
 		}
-
-		// Close zip writer
-		if err := zipWriter.Close(); err != nil {
-			http.Error(w, "failed to finalize zip", http.StatusInternalServerError)
-			return true
+		if err != nil {
+			abortStream(w, r, sw, "download "+path, err)
 		}
-
-		// Send zip file
-		w.Header().Set("Content-Type", "application/zip")
-		w.Header().Set("Content-Disposition", "attachment; filename=\""+zipFilename+"\"")
-		w.Header().Set("Content-Length", strconv.Itoa(zipBuf.Len()))
-		w.Write(zipBuf.Bytes())
 		return true
 	}
 
@@ -729,22 +1274,54 @@ func serveMarkdown(w http.ResponseWriter, r *http.Request, path string) bool {
 		browsePath = dir + "/"
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	mdTemplate.Execute(w, struct {
+	// The page is current if its source, the links to it and the serve
+	// build are unchanged, so a revalidating browser needn't get it again.
+	parts := [][]byte{[]byte(path), []byte(browsePath), rendered.source}
+	if rendered.wiki != "" {
+		parts = append(parts, []byte(rendered.wiki))
+	}
+	mod := rendered.modTime()
+	for _, b := range backlinks {
+		parts = append(parts, []byte(b.Path), []byte(b.Title))
+		if b.mod.After(mod) {
+			mod = b.mod
+		}
+	}
+	etag := contentETag(parts...)
+	if notModified(w, r, etag, mod) {
+		return true
+	}
+
+	var buf bytes.Buffer
+	err = mdTemplate.Execute(&buf, struct {
 		Title      string
 		BaseCSS    template.CSS
 		Content    template.HTML
+		TOC        template.HTML
+		Meta       pageMeta
 		CustomCSS  template.CSS
 		BrowsePath string
 		ExportPath string
+		LiveReload string
+		Backlinks  []backlink
 	}{
-		Title:      filepath.Base(path),
-		BaseCSS:    template.CSS(markdownCSS),
-		Content:    template.HTML(buf.String()),
+		Title:      page.title(filepath.Base(path)),
+		BaseCSS:    template.CSS(markdownCSS + highlightCSS),
+		Content:    page.Content,
+		TOC:        page.TOC,
+		Meta:       page.Meta,
 		CustomCSS:  template.CSS(customCSS),
 		BrowsePath: browsePath,
 		ExportPath: dir + "/?export",
+		LiveReload: liveReloadURL(path),
+		Backlinks:  backlinks,
 	})
+	if err != nil {
+		http.Error(w, "failed to generate HTML", http.StatusInternalServerError)
+		return true
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	serveRendered(w, r, etag, mod, buf.Bytes())
 	return true
 }
 
@@ -755,6 +1332,13 @@ func getMimeType(name string) string {
 	return "application/octet-stream"
 }
 
+// wantsJSON reports whether the client asked for JSON, with ?format=json
+// or an Accept header, rather than an HTML page.
+func wantsJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json" ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
 var imgRegex = regexp.MustCompile(`(<img\b[^>]*?\ssrc=")([^"]+)(")`)
 
 func embedImages(htmlContent []byte, basePath string) ([]byte, error) {
@@ -790,8 +1374,10 @@ func embedImages(htmlContent []byte, basePath string) ([]byte, error) {
 	return result, nil
 }
 
-// serveDirList renders a directory listing with a "Download HTML zip" link.
-// It defers to the file server (returns false) for directories that contain an
+// serveDirList renders a directory listing with a "Download HTML zip" link,
+// sorted by ?sort=name|size|mtime and ?order=asc|desc with directories first.
+// Clients asking for JSON get the listing as data instead. Otherwise it
+// defers to the file server (returns false) for directories that contain an
 // index.html so that default behavior is preserved.
 func serveDirList(w http.ResponseWriter, r *http.Request, urlPath string) bool {
 	dir := filepath.Clean(strings.TrimPrefix(urlPath, "/"))
@@ -801,6 +1387,10 @@ func serveDirList(w http.ResponseWriter, r *http.Request, urlPath string) bool {
 	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
 		return false
 	}
+	if wantsJSON(r) {
+		serveDirListJSON(w, r, dir, urlPath)
+		return true
+	}
 	if _, err := os.Stat(filepath.Join(dir, "index.html")); err == nil {
 		return false
 	}
@@ -809,35 +1399,42 @@ func serveDirList(w http.ResponseWriter, r *http.Request, urlPath string) bool {
 		return false
 	}
 
-	type listEntry struct{ Name, Href string }
 	list := make([]listEntry, 0, len(entries))
 	for _, e := range entries {
-		name := e.Name()
-		if e.IsDir() {
-			name += "/"
+		if accessAllowed(r, urlPath+e.Name()) {
+			list = append(list, newListEntry(dir, e))
 		}
-		href := (&url.URL{Path: name}).String()
-		list = append(list, listEntry{Name: name, Href: href})
 	}
+	sorting := listSortFrom(r)
+	sortEntries(list, sorting)
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	dirListTemplate.Execute(w, struct {
-		Title     string
-		BaseCSS   template.CSS
-		CustomCSS template.CSS
-		Entries   []listEntry
+	var buf bytes.Buffer
+	err = dirListTemplate.Execute(&buf, struct {
+		Title      string
+		BaseCSS    template.CSS
+		CustomCSS  template.CSS
+		Crumbs     []crumb
+		Columns    []listColumn
+		Entries    []listEntry
+		LiveReload string
 	}{
-		Title:     urlPath,
-		BaseCSS:   template.CSS(markdownCSS),
-		CustomCSS: template.CSS(customCSS),
-		Entries:   list,
+		Title:      urlPath,
+		BaseCSS:    template.CSS(markdownCSS),
+		CustomCSS:  template.CSS(customCSS),
+		Crumbs:     breadcrumbs(urlPath),
+		Columns:    listColumns(r, sorting),
+		Entries:    list,
+		LiveReload: liveReloadURL(urlPath),
 	})
+	if err != nil {
+		http.Error(w, "failed to list directory", http.StatusInternalServerError)
+		return true
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	serveRendered(w, r, contentETag(buf.Bytes()), listModTime(dir, list), buf.Bytes())
 	return true
 }
 
-// serveExport walks the directory at urlPath and streams a zip in which every
-// .md file is rendered to standalone HTML (with inter-page .md links rewritten
-// to .html) and all other files are copied verbatim, preserving structure.
 // zipEntry creates a deflated zip entry that carries the given modification
 // time, so extracted files keep the source's timestamp instead of the 1980
 // zero-date that zip.Writer.Create leaves.
@@ -849,6 +1446,10 @@ func zipEntry(zw *zip.Writer, name string, mod time.Time) (io.Writer, error) {
 	})
 }
 
+// serveExport walks the directory at urlPath and streams a zip in which every
+// .md file is rendered to standalone HTML (with inter-page .md links rewritten
+// to .html) and all other files are copied verbatim, preserving structure.
+// Pages marked "draft: true" in their front matter are left out.
 func serveExport(w http.ResponseWriter, r *http.Request, urlPath string) bool {
 	root := filepath.Clean(strings.TrimPrefix(urlPath, "/"))
 	if strings.HasPrefix(root, "..") {
@@ -867,97 +1468,41 @@ func serveExport(w http.ResponseWriter, r *http.Request, urlPath string) bool {
 		}
 	}
 
-	var zipBuf bytes.Buffer
-	zw := zip.NewWriter(&zipBuf)
-
-	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if d.IsDir() {
-			if name := d.Name(); p != root && (name == ".serve" || name == ".git") {
-				return fs.SkipDir
-			}
-			return nil
-		}
-
-		rel, err := filepath.Rel(root, p)
-		if err != nil {
-			return err
-		}
-		rel = filepath.ToSlash(rel)
-
-		info, err := d.Info()
-		if err != nil {
-			return err
-		}
-		mod := info.ModTime()
-
-		if !strings.HasSuffix(strings.ToLower(d.Name()), ".md") {
-			f, err := zipEntry(zw, rel, mod)
-			if err != nil {
-				return err
-			}
-			src, err := os.Open(p)
-			if err != nil {
-				return err
-			}
-			defer src.Close()
-			_, err = io.Copy(f, src)
-			return err
-		}
-
-		content, err := os.ReadFile(p)
-		if err != nil {
-			return err
-		}
-		var body bytes.Buffer
-		if err := md.Convert(content, &body); err != nil {
-			return err
-		}
-		var htmlBuf bytes.Buffer
-		if err := mdTemplateStandalone.Execute(&htmlBuf, struct {
-			Title     string
-			BaseCSS   template.CSS
-			Content   template.HTML
-			CustomCSS template.CSS
-		}{
-			Title:     strings.TrimSuffix(d.Name(), filepath.Ext(d.Name())),
-			BaseCSS:   template.CSS(markdownCSS),
-			Content:   template.HTML(body.String()),
-			CustomCSS: template.CSS(customCSS),
-		}); err != nil {
-			return err
-		}
-
-		htmlRel := strings.TrimSuffix(rel, filepath.Ext(rel)) + ".html"
-		f, err := zipEntry(zw, htmlRel, mod)
-		if err != nil {
-			return err
-		}
-		_, err = f.Write(rewriteMarkdownLinks(htmlBuf.Bytes()))
-		return err
-	})
-	if err != nil {
-		http.Error(w, "failed to export: "+err.Error(), http.StatusInternalServerError)
+	if aclFor(r).restricts(identity(r), urlPath) {
+		denyAccess(w, r, "export of a tree with files they can't see")
 		return true
 	}
-	if err := zw.Close(); err != nil {
-		http.Error(w, "failed to finalize zip", http.StatusInternalServerError)
+
+	format, ok := exportFormats[r.URL.Query().Get("export")]
+	if !ok {
+		http.Error(w, "unknown export format; use zip or tar.gz", http.StatusBadRequest)
 		return true
 	}
 
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", "attachment; filename=\""+base+".zip\"")
-	w.Header().Set("Content-Length", strconv.Itoa(zipBuf.Len()))
-	w.Write(zipBuf.Bytes())
+	// Stream the archive as it's built; a large tree never sits in memory.
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+base+format.ext+"\"")
+	sw := &streamWriter{w: w}
+	ew := format.newWriter(sw)
+	err := writeExport(r.Context(), ew, root)
+	if err == nil {
+		err = ew.Close()
+	}
+	if err != nil {
+		abortStream(w, r, sw, "export "+urlPath, err)
+	}
 	return true
 }
 
 // rewriteMarkdownLinks rewrites relative <a href> targets that point at .md
-// files to their .html counterparts, preserving any #fragment and leaving
-// external, absolute, and anchor-only links untouched.
-func rewriteMarkdownLinks(html []byte) []byte {
+// files to their .html counterparts, and those that point at directories
+// to their index.html, preserving any #fragment and leaving external,
+// absolute, and anchor-only links untouched. Links to a directory's -index
+// file go to its index.html, where exports put it. from is the
+// slash-separated path of the page within the export, and outs maps pages
+// exported under other names, such as a -index file beside a directory's
+// own index.html, to where they went.
+func rewriteMarkdownLinks(html []byte, from string, outs map[string]string) []byte {
 	linkRegex := regexp.MustCompile(`(<a\b[^>]*\shref=")([^"]+)(")`)
 	return linkRegex.ReplaceAllFunc(html, func(match []byte) []byte {
 		m := linkRegex.FindSubmatch(match)
@@ -971,10 +1516,20 @@ func rewriteMarkdownLinks(html []byte) []byte {
 			return match
 		}
 		target, frag, hasFrag := strings.Cut(href, "#")
-		if !strings.HasSuffix(strings.ToLower(target), ".md") {
+		dir, base := target[:strings.LastIndex(target, "/")+1], target[strings.LastIndex(target, "/")+1:]
+		switch {
+		case outs[exportTarget(from, target)] != "":
+			base = path.Base(outs[exportTarget(from, target)])
+			target = dir + (&url.URL{Path: base}).String()
+		case *index != "" && base == *index:
+			target = dir + "index.html"
+		case strings.HasSuffix(strings.ToLower(target), ".md"):
+			target = target[:len(target)-len(".md")] + ".html"
+		case strings.HasSuffix(target, "/") && !strings.HasPrefix(target, "/"):
+			target += "index.html"
+		default:
 			return match
 		}
-		target = target[:len(target)-len(".md")] + ".html"
 		if hasFrag {
 			target += "#" + frag
 		}
@@ -982,6 +1537,20 @@ func rewriteMarkdownLinks(html []byte) []byte {
 	})
 }
 
+// exportTarget returns the slash-separated path within an export of the
+// escaped link target, linked from the page at from, or "" if it's a
+// directory or can't be unescaped.
+func exportTarget(from, target string) string {
+	p, err := url.PathUnescape(target)
+	if err != nil || p == "" || strings.HasSuffix(p, "/") {
+		return ""
+	}
+	if strings.HasPrefix(p, "/") {
+		return path.Clean(p[1:])
+	}
+	return path.Join(path.Dir(from), p)
+}
+
 func ensureGitignore() {
 	const entry = ".serve/"
 	const comment = "# Comment the line below if you really want to commit .serve/"
@@ -69,12 +69,29 @@ func TestRewriteMarkdownLinks(t *testing.T) {
 		{`<a href="https://x.com/a.md">x</a>`, `<a href="https://x.com/a.md">x</a>`},
 		{`<a href="#frag">x</a>`, `<a href="#frag">x</a>`},
 		{`<a href="img.png">x</a>`, `<a href="img.png">x</a>`},
+		{`<a href="../README.md#top">x</a>`, `<a href="../index.html#top">x</a>`},
+		{`<a href="docs/">x</a>`, `<a href="docs/index.html">x</a>`},
+		{`<a href="/docs/">x</a>`, `<a href="/docs/">x</a>`},
 	}
 	for _, c := range cases {
-		if got := string(rewriteMarkdownLinks([]byte(c.in))); got != c.want {
+		if got := string(rewriteMarkdownLinks([]byte(c.in), "docs/page.html", nil)); got != c.want {
 			t.Errorf("rewriteMarkdownLinks(%q) = %q, want %q", c.in, got, c.want)
 		}
 	}
+
+	// Pages exported under other names are linked to there.
+	outs := map[string]string{"README.md": "README.html", "docs/index.md": "docs/index.md.html", "docs/my doc.md": "docs/my doc.md.html"}
+	for in, want := range map[string]string{
+		`<a href="../README.md#top">x</a>`: `<a href="../README.html#top">x</a>`,
+		`<a href="/README.md">x</a>`:       `<a href="/README.html">x</a>`,
+		`<a href="README.md">x</a>`:        `<a href="index.html">x</a>`,
+		`<a href="index.md">x</a>`:         `<a href="index.md.html">x</a>`,
+		`<a href="my%20doc.md#a">x</a>`:    `<a href="my%20doc.md.html#a">x</a>`,
+	} {
+		if got := string(rewriteMarkdownLinks([]byte(in), "docs/page.html", outs)); got != want {
+			t.Errorf("rewriteMarkdownLinks(%q) with renamed pages = %q, want %q", in, got, want)
+		}
+	}
 }
 
 func TestGithubSlug(t *testing.T) {
@@ -118,6 +135,9 @@ func readZip(t *testing.T, raw []byte) map[string]*zip.File {
 	}
 	m := make(map[string]*zip.File, len(zr.File))
 	for _, f := range zr.File {
+		if m[f.Name] != nil {
+			t.Errorf("zip has %s twice", f.Name)
+		}
 		m[f.Name] = f
 	}
 	return m
@@ -160,7 +180,7 @@ func TestServeExport(t *testing.T) {
 	files := readZip(t, rec.Body.Bytes())
 
 	// .md files become .html; assets are copied verbatim; vcs/state dirs excluded.
-	for _, name := range []string{"README.html", "docs/guide.html", "logo.png"} {
+	for _, name := range []string{"index.html", "docs/guide.html", "logo.png"} {
 		if files[name] == nil {
 			t.Errorf("missing zip entry %q", name)
 		}
@@ -176,20 +196,20 @@ func TestServeExport(t *testing.T) {
 
 	// Inter-page .md links are rewritten to .html (fragment preserved), external
 	// links untouched.
-	home := zipBody(t, files["README.html"])
+	home := zipBody(t, files["index.html"])
 	if !strings.Contains(home, `href="docs/guide.html#sec"`) {
-		t.Errorf("link not rewritten in README.html: %s", home)
+		t.Errorf("link not rewritten in index.html: %s", home)
 	}
 	if !strings.Contains(home, `href="https://x.com/a.md"`) {
 		t.Errorf("external link should be untouched: %s", home)
 	}
-	if !strings.Contains(zipBody(t, files["docs/guide.html"]), `href="../README.html"`) {
+	if !strings.Contains(zipBody(t, files["docs/guide.html"]), `href="../index.html"`) {
 		t.Error("relative up-path link not rewritten in guide.html")
 	}
 
 	// Generated HTML carries the source .md's mod time, not the 1980 zero-date.
-	if delta := files["README.html"].Modified.Sub(mod); delta < -2*time.Second || delta > 2*time.Second {
-		t.Errorf("README.html mod time = %v, want ~%v", files["README.html"].Modified, mod)
+	if delta := files["index.html"].Modified.Sub(mod); delta < -2*time.Second || delta > 2*time.Second {
+		t.Errorf("index.html mod time = %v, want ~%v", files["index.html"].Modified, mod)
 	}
 	if delta := files["logo.png"].Modified.Sub(mod); delta < -2*time.Second || delta > 2*time.Second {
 		t.Errorf("logo.png mod time = %v, want ~%v", files["logo.png"].Modified, mod)
@@ -0,0 +1,57 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+)
+
+// streamWriter passes writes through to an http.ResponseWriter, noting
+// whether any have been made and so whether the response has started.
+type streamWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	s.started = true
+	return s.w.Write(p)
+}
+
+// contextReader fails reads once ctx is done, so a long copy into a
+// streamed response stops soon after the client goes away.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// abortStream ends a streamed response that failed with err. Before
+// anything is sent the client gets an ordinary error. After that the status
+// is gone, so the connection is cut instead of ending the response cleanly,
+// which would let a truncated download pass for a complete one. Requests
+// that were canceled, by the client or by shutdown, are not logged.
+func abortStream(w http.ResponseWriter, r *http.Request, s *streamWriter, what string, err error) {
+	canceled := r.Context().Err() != nil
+	if !canceled {
+		log.Printf("%s failed: %v", what, err)
+	}
+	if !s.started {
+		if !canceled {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, what+" failed: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	panic(http.ErrAbortHandler)
+}
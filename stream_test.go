@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeExportStreams(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "README.md", "# Home\n", time.Time{})
+	writeFile(t, dir, "data.bin", strings.Repeat("x", 100_000), time.Time{})
+
+	rec := httptest.NewRecorder()
+	serveExport(rec, httptest.NewRequest("GET", "/?export", nil), "/")
+	if cl := rec.Header().Get("Content-Length"); cl != "" {
+		t.Errorf("Content-Length = %s; the zip should be streamed", cl)
+	}
+	files := readZip(t, rec.Body.Bytes())
+	if files["index.html"] == nil || len(zipBody(t, files["data.bin"])) != 100_000 {
+		t.Errorf("incomplete zip: %v", files)
+	}
+}
+
+func TestServeExportCanceled(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "README.md", "# Home\n", time.Time{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	if !serveExport(rec, httptest.NewRequest("GET", "/?export", nil).WithContext(ctx), "/") {
+		t.Fatal("serveExport returned false")
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("canceled export wrote %d bytes", rec.Body.Len())
+	}
+}
+
+func TestServeExportErrorBeforeStreaming(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.Symlink("missing", filepath.Join(dir, "broken")); err != nil {
+		t.Skip(err)
+	}
+
+	rec := httptest.NewRecorder()
+	serveExport(rec, httptest.NewRequest("GET", "/?export", nil), "/")
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("Content-Disposition") != "" {
+		t.Errorf("got %d with Content-Disposition %q, want a plain 500", rec.Code, rec.Header().Get("Content-Disposition"))
+	}
+}
+
+func TestServeExportErrorMidStream(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	big := make([]byte, 1<<20)
+	for i := range big {
+		big[i] = byte(i * 7919 >> 3)
+	}
+	writeFile(t, dir, "a.bin", string(big), time.Time{})
+	if err := os.Symlink("missing", filepath.Join(dir, "z-broken")); err != nil {
+		t.Skip(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveExport(w, r, "/")
+	}))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/?export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d; the error came after streaming started", resp.StatusCode)
+	}
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Error("truncated export read cleanly; the connection should be cut")
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// tocMaxLevel is the deepest heading level listed in a table of contents.
+const tocMaxLevel = 3
+
+// tocEntry is a heading collected for the table of contents.
+type tocEntry struct {
+	Level int
+	ID    string
+	Text  string
+}
+
+// tocKey holds the []tocEntry collected while parsing a document. When the
+// document places its own TOC, tocPlacedKey is set so the sidebar is omitted.
+var (
+	tocKey       = parser.NewContextKey()
+	tocPlacedKey = parser.NewContextKey()
+)
+
+// tableOfContents collects headings (after githubHeadingIDs has assigned
+// their IDs) and replaces a "[[toc]]" paragraph or "<!-- toc -->" comment
+// with a nested list of links to them.
+type tableOfContents struct{}
+
+func (tableOfContents) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(tocTransformer{}, 200),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(tocRenderer{}, 100),
+	))
+}
+
+var kindTOC = ast.NewNodeKind("TOC")
+
+// tocBlock is the node that stands in for a TOC placeholder.
+type tocBlock struct {
+	ast.BaseBlock
+	Entries []tocEntry
+}
+
+func (n *tocBlock) Kind() ast.NodeKind { return kindTOC }
+
+func (n *tocBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+type tocTransformer struct{}
+
+func (tocTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var entries []tocEntry
+	var placeholders []ast.Node
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Heading:
+			if n.Level <= tocMaxLevel {
+				if id, ok := n.AttributeString("id"); ok {
+					entries = append(entries, tocEntry{
+						Level: n.Level,
+						ID:    string(id.([]byte)),
+						Text:  headingText(n, source),
+					})
+				}
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.Paragraph, *ast.HTMLBlock:
+			if isTOCPlaceholder(n, source) {
+				placeholders = append(placeholders, n)
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+
+	pc.Set(tocKey, entries)
+	for _, p := range placeholders {
+		p.Parent().ReplaceChild(p.Parent(), p, &tocBlock{Entries: entries})
+		pc.Set(tocPlacedKey, true)
+	}
+}
+
+// isTOCPlaceholder reports whether n is a block consisting solely of
+// "[[toc]]" or "<!-- toc -->" (case-insensitively).
+func isTOCPlaceholder(n ast.Node, source []byte) bool {
+	var raw []byte
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		seg := lines.At(i)
+		raw = append(raw, seg.Value(source)...)
+	}
+	s := strings.ToLower(string(bytes.TrimSpace(raw)))
+	switch n.(type) {
+	case *ast.Paragraph:
+		return s == "[[toc]]"
+	case *ast.HTMLBlock:
+		s, ok := strings.CutPrefix(s, "<!--")
+		if !ok {
+			return false
+		}
+		s, ok = strings.CutSuffix(s, "-->")
+		return ok && strings.TrimSpace(s) == "toc"
+	}
+	return false
+}
+
+type tocRenderer struct{}
+
+func (tocRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindTOC, func(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			w.WriteString(`<nav class="toc">` + "\n")
+			w.WriteString(tocList(n.(*tocBlock).Entries))
+			w.WriteString("</nav>\n")
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// tocList renders entries as nested <ul> lists, nesting relative to the
+// shallowest heading so a document without an <h1> doesn't start indented.
+func tocList(entries []tocEntry) string {
+	if len(entries) == 0 {
+		return ""
+	}
+	base := entries[0].Level
+	for _, e := range entries {
+		base = min(base, e.Level)
+	}
+
+	var b strings.Builder
+	depth := 0
+	for _, e := range entries {
+		level := e.Level - base + 1
+		if level > depth {
+			// Open lists until we reach this level; skipped levels get an
+			// empty item so the markup stays valid.
+			for depth < level {
+				b.WriteString("<ul>\n")
+				depth++
+				if depth < level {
+					b.WriteString("<li>")
+				}
+			}
+		} else {
+			b.WriteString("</li>\n")
+			for depth > level {
+				b.WriteString("</ul>\n</li>\n")
+				depth--
+			}
+		}
+		b.WriteString(`<li><a href="#` + html.EscapeString(e.ID) + `">` + html.EscapeString(e.Text) + "</a>")
+	}
+	b.WriteString("</li>\n")
+	for ; depth > 1; depth-- {
+		b.WriteString("</ul>\n</li>\n")
+	}
+	b.WriteString("</ul>\n")
+	return b.String()
+}
+
+// sidebarTOC returns the table of contents to show alongside a page parsed
+// with pc, or "" if the page placed its own or has too few headings to need
+// one.
+func sidebarTOC(pc parser.Context) template.HTML {
+	if pc.Get(tocPlacedKey) != nil {
+		return ""
+	}
+	entries, _ := pc.Get(tocKey).([]tocEntry)
+	if len(entries) < 2 {
+		return ""
+	}
+	return template.HTML(tocList(entries))
+}
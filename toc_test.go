@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOCList(t *testing.T) {
+	cases := []struct {
+		name    string
+		entries []tocEntry
+		want    string
+	}{
+		{
+			name:    "nested",
+			entries: []tocEntry{{2, "a", "A"}, {3, "b", "B"}, {2, "c", "C & D"}},
+			want: "<ul>\n" +
+				`<li><a href="#a">A</a><ul>` + "\n" +
+				`<li><a href="#b">B</a></li>` + "\n" +
+				"</ul>\n</li>\n" +
+				`<li><a href="#c">C &amp; D</a></li>` + "\n" +
+				"</ul>\n",
+		},
+		{
+			name:    "skipped level",
+			entries: []tocEntry{{1, "a", "A"}, {3, "b", "B"}},
+			want: "<ul>\n" +
+				`<li><a href="#a">A</a><ul>` + "\n" +
+				"<li><ul>\n" +
+				`<li><a href="#b">B</a></li>` + "\n" +
+				"</ul>\n</li>\n" +
+				"</ul>\n</li>\n" +
+				"</ul>\n",
+		},
+	}
+	for _, c := range cases {
+		if got := tocList(c.entries); got != c.want {
+			t.Errorf("%s: tocList =\n%s\nwant\n%s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRenderMarkdownTOCPlaceholder(t *testing.T) {
+	for _, placeholder := range []string{"[[toc]]", "<!-- TOC -->"} {
+		src := "# Title\n\n" + placeholder + "\n\n## First\n\n## Second\n\n#### Too deep\n"
+		page, err := renderMarkdown([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(page.Content)
+		if strings.Contains(got, placeholder) || !strings.Contains(got, `<nav class="toc">`) {
+			t.Errorf("%s: placeholder not replaced: %s", placeholder, got)
+		}
+		if !strings.Contains(got, `<a href="#second">Second</a>`) {
+			t.Errorf("%s: heading after placeholder missing from TOC: %s", placeholder, got)
+		}
+		if strings.Contains(got, `href="#too-deep"`) {
+			t.Errorf("%s: h4 should not be listed: %s", placeholder, got)
+		}
+		if page.TOC != "" {
+			t.Errorf("%s: sidebar should be omitted when the page places its own TOC", placeholder)
+		}
+	}
+}
+
+func TestServeMarkdownTOCSidebar(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "long.md", "# Long\n\n## Part one\n\n## Part one\n", time.Time{})
+	writeFile(t, dir, "short.md", "# Short\n\nJust text.\n", time.Time{})
+
+	rec := httptest.NewRecorder()
+	if !serveMarkdown(rec, httptest.NewRequest("GET", "/long.md", nil), "/long.md") {
+		t.Fatal("serveMarkdown returned false")
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `<nav class="toc">`) || !strings.Contains(body, `<a href="#part-one-1">Part one</a>`) {
+		t.Errorf("sidebar TOC missing or not using heading IDs: %s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	serveMarkdown(rec, httptest.NewRequest("GET", "/short.md", nil), "/short.md")
+	if strings.Contains(rec.Body.String(), `<nav class="toc">`) {
+		t.Error("single-heading page should not get a sidebar TOC")
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// treeChanges is the set of directories in a tree that have changed since
+// an index last brought them up to date. Indexes take the changes in the
+// part of the tree they're about to use, so nothing is walked or statted
+// until something has changed there.
+type treeChanges struct {
+	mu      sync.Mutex
+	dirty   map[string]bool // slash-separated dir ("." for the root) -> whether everything below it changed too
+	watched bool            // if not, the whole tree is always taken to have changed
+}
+
+// take returns the changed directories at or under dir, mapped to whether
+// everything below them changed too, and forgets them. A change to a whole
+// tree that contains dir is returned as all of dir, and kept for the rest of
+// that tree.
+func (c *treeChanges) take(dir string) map[string]bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.watched {
+		return map[string]bool{dir: true}
+	}
+	taken := make(map[string]bool)
+	for d, tree := range c.dirty {
+		switch {
+		case inDir(d, dir):
+			taken[d] = taken[d] || tree
+			delete(c.dirty, d)
+		case tree && inDir(dir, d):
+			taken[dir] = true
+		}
+	}
+	return taken
+}
+
+func (c *treeChanges) mark(dir string, tree bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dirty[dir] = c.dirty[dir] || tree
+}
+
+// inDir reports whether the slash-separated path p is dir or inside it.
+func inDir(p, dir string) bool {
+	return dir == "." || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// syncChanged walks the changed directories of the tree at the working
+// directory, calling update for each file in them and remove for each of
+// paths (the files an index knows of) that's in them but gone.
+func syncChanged(changed map[string]bool, paths []string, update func(rel string, d fs.DirEntry), remove func(rel string)) {
+	seen := make(map[string]bool)
+	visit := func(p string, d fs.DirEntry) {
+		rel := filepath.ToSlash(p)
+		seen[rel] = true
+		update(rel, d)
+	}
+	for dir, tree := range changed {
+		if !tree {
+			entries, _ := os.ReadDir(filepath.FromSlash(dir))
+			for _, d := range entries {
+				if !d.IsDir() {
+					visit(path.Join(dir, d.Name()), d)
+				}
+			}
+			continue
+		}
+		filepath.WalkDir(filepath.FromSlash(dir), func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil // unreadable or gone; leave it out
+			}
+			if d.IsDir() {
+				if filepath.ToSlash(p) != dir && skippedDir(d.Name()) {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			visit(p, d)
+			return nil
+		})
+	}
+	for _, rel := range paths {
+		if seen[rel] {
+			continue
+		}
+		for dir, tree := range changed {
+			if path.Dir(rel) == dir || tree && inDir(rel, dir) {
+				remove(rel)
+				break
+			}
+		}
+	}
+}
+
+// treeWatcher watches every directory of a tree with fsnotify, marking the
+// ones that change in each of its treeChanges. It has its own fsnotify
+// watcher, so live reload's watches come and go without disturbing it.
+type treeWatcher struct {
+	root string
+	fsw  *fsnotify.Watcher
+
+	mu   sync.Mutex
+	dirs map[string]bool // watched directories, slash-separated relative to root
+	sets []*treeChanges
+}
+
+var (
+	treesMu sync.Mutex
+	trees   = make(map[string]*treeWatcher) // by absolute root
+)
+
+// watchTree returns a new set of changes to the tree at the absolute path
+// root, starting with everything changed. If the tree can't be watched,
+// everything stays changed, so indexes re-read it on each use.
+func watchTree(root string) *treeChanges {
+	c := &treeChanges{dirty: map[string]bool{".": true}}
+	treesMu.Lock()
+	defer treesMu.Unlock()
+	tw := trees[root]
+	if tw == nil {
+		var err error
+		if tw, err = newTreeWatcher(root); err != nil {
+			log.Printf("watch error, rescanning %s on each use: %v", root, err)
+			return c
+		}
+		trees[root] = tw
+	}
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	c.watched = true
+	tw.sets = append(tw.sets, c)
+	return c
+}
+
+func newTreeWatcher(root string) (*treeWatcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	tw := &treeWatcher{root: root, fsw: fsw, dirs: make(map[string]bool)}
+	if err := tw.add("."); err != nil {
+		fsw.Close()
+		return nil, err
+	}
+	go tw.run()
+	return tw, nil
+}
+
+// add watches the directory rel and those below it. The caller holds tw.mu
+// or hasn't started tw yet.
+func (tw *treeWatcher) add(rel string) error {
+	start := filepath.Join(tw.root, filepath.FromSlash(rel))
+	return filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // gone again, or unreadable
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if p != start && skippedDir(d.Name()) {
+			return fs.SkipDir
+		}
+		if err := tw.fsw.Add(p); err != nil {
+			return err
+		}
+		r, _ := filepath.Rel(tw.root, p)
+		tw.dirs[filepath.ToSlash(r)] = true
+		return nil
+	})
+}
+
+func (tw *treeWatcher) run() {
+	for {
+		select {
+		case ev, ok := <-tw.fsw.Events:
+			if !ok {
+				return
+			}
+			if ev.Op == fsnotify.Chmod {
+				continue
+			}
+			if gone, err := tw.changed(ev); gone || err != nil {
+				if err != nil {
+					log.Printf("watch error, rescanning %s on each use: %v", tw.root, err)
+				}
+				tw.stop()
+				return
+			}
+		case err, ok := <-tw.fsw.Errors:
+			if !ok {
+				return
+			}
+			// Events were lost, perhaps to an overflow.
+			log.Printf("watch error: %v", err)
+			tw.markAll(".", true)
+		}
+	}
+}
+
+// changed marks the directory of the file or directory ev is about as
+// changed, and all of it if it's a directory that came or went. It reports
+// whether the tree itself is gone.
+func (tw *treeWatcher) changed(ev fsnotify.Event) (gone bool, err error) {
+	r, err := filepath.Rel(tw.root, ev.Name)
+	if err != nil {
+		return false, nil
+	}
+	rel := filepath.ToSlash(r)
+	if rel == "." {
+		return ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename), nil
+	}
+
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	isDir := tw.dirs[rel]
+	if isDir && (ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename)) {
+		for d := range tw.dirs {
+			if inDir(d, rel) {
+				tw.fsw.Remove(filepath.Join(tw.root, filepath.FromSlash(d)))
+				delete(tw.dirs, d)
+			}
+		}
+	}
+	if ev.Has(fsnotify.Create) {
+		if info, err := os.Lstat(ev.Name); err == nil && info.IsDir() && !skippedDir(info.Name()) {
+			// Watch it before it's re-read, so nothing made in it is missed.
+			if err := tw.add(rel); err != nil {
+				return false, err
+			}
+			isDir = true
+		}
+	}
+	for _, c := range tw.sets {
+		c.mark(path.Dir(rel), false)
+		if isDir {
+			c.mark(rel, true)
+		}
+	}
+	return false, nil
+}
+
+func (tw *treeWatcher) markAll(dir string, tree bool) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	for _, c := range tw.sets {
+		c.mark(dir, tree)
+	}
+}
+
+// stop gives up watching, leaving every set to treat the whole tree as
+// changed from now on.
+func (tw *treeWatcher) stop() {
+	treesMu.Lock()
+	if trees[tw.root] == tw {
+		delete(trees, tw.root)
+	}
+	treesMu.Unlock()
+	tw.fsw.Close()
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	for _, c := range tw.sets {
+		c.mu.Lock()
+		c.watched = false
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventually retries check for a few seconds until it passes, for changes
+// that reach indexes through the file watcher.
+func eventually(t *testing.T, check func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !check() && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTreeChangesTake(t *testing.T) {
+	c := &treeChanges{watched: true, dirty: map[string]bool{".": true}}
+
+	// A scoped take walks just its part of a changed tree, and the rest is
+	// still to do.
+	if got := c.take("docs"); !maps.Equal(got, map[string]bool{"docs": true}) {
+		t.Errorf("take(docs) = %v", got)
+	}
+	c.mark("docs/api", false)
+	c.mark("notes", false)
+	if got := c.take("docs"); !maps.Equal(got, map[string]bool{"docs": true, "docs/api": false}) {
+		t.Errorf("take(docs) = %v", got)
+	}
+	if got := c.take("."); !maps.Equal(got, map[string]bool{".": true, "notes": false}) {
+		t.Errorf("take(.) = %v", got)
+	}
+	if got := c.take("."); len(got) != 0 {
+		t.Errorf("take(.) with nothing changed = %v", got)
+	}
+
+	unwatched := &treeChanges{}
+	if got := unwatched.take("docs"); !maps.Equal(got, map[string]bool{"docs": true}) {
+		t.Errorf("unwatched take(docs) = %v", got)
+	}
+}
+
+func TestWatchTree(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "docs/a.md", "a", time.Time{})
+	writeFile(t, dir, ".git/HEAD", "ref", time.Time{})
+	c := watchTree(dir)
+	if !c.watched {
+		t.Skip("can't watch files here")
+	}
+	c.take(".")
+
+	// changes waits for the changes taken to add up to want, leaving out
+	// those inside a directory that changed whole.
+	changes := func(want string) {
+		t.Helper()
+		taken := make(map[string]bool)
+		var got string
+		eventually(t, func() bool {
+			for d, tree := range c.take(".") {
+				taken[d] = taken[d] || tree
+			}
+			var list []string
+			for d, tree := range taken {
+				covered := false
+				for e, whole := range taken {
+					covered = covered || whole && e != d && inDir(d, e)
+				}
+				if tree {
+					d += "/**"
+				}
+				if !covered {
+					list = append(list, d)
+				}
+			}
+			slices.Sort(list)
+			got = strings.Join(list, " ")
+			return got == want
+		})
+		if got != want {
+			t.Errorf("changes = %s, want %s", got, want)
+		}
+	}
+
+	writeFile(t, dir, "docs/a.md", "edited", time.Time{})
+	changes("docs")
+
+	// New directories are watched, and read whole.
+	writeFile(t, dir, "notes/deep/b.md", "b", time.Time{})
+	changes(". notes/**")
+	// Events from making notes/deep may still be on their way; let them
+	// arrive and drop them, so only the new file's change is left.
+	time.Sleep(50 * time.Millisecond)
+	c.take(".")
+	writeFile(t, dir, "notes/deep/c.md", "c", time.Time{})
+	changes("notes/deep")
+
+	if err := os.RemoveAll(filepath.Join(dir, "docs")); err != nil {
+		t.Fatal(err)
+	}
+	changes(". docs/**")
+
+	// What happens under .git isn't watched.
+	writeFile(t, dir, ".git/HEAD", "other", time.Time{})
+	time.Sleep(50 * time.Millisecond)
+	if got := c.take("."); len(got) != 0 {
+		t.Errorf("changes under .git: %v", got)
+	}
+}
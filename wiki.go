@@ -0,0 +1,444 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"hash/fnv"
+	"html"
+	"io/fs"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// wikiLinks adds wiki-style links: [[Page]], [[Page#Heading]] and
+// [[Page|label]]. Page names a markdown file anywhere in the tree, with or
+// without its .md extension and with as much of its path as it takes to
+// tell it apart; the match nearest the linking page wins. [[toc]] is left
+// alone for tableOfContents.
+type wikiLinks struct{}
+
+func (wikiLinks) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		// Ahead of the standard link parser (200), which also starts at '['.
+		parser.WithInlineParsers(util.Prioritized(wikiLinkParser{}, 199)),
+		parser.WithASTTransformers(util.Prioritized(wikiLinkResolver{}, 100)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(wikiLinkRenderer{}, 100),
+	))
+}
+
+var kindWikiLink = ast.NewNodeKind("WikiLink")
+
+// wikiLink is a [[link]]. Its child is the label.
+type wikiLink struct {
+	ast.BaseInline
+	Page    string // as written; empty for a heading on the same page
+	Heading string
+	Dest    string // URL of the page it resolved to; empty if there's none
+}
+
+func (n *wikiLink) Kind() ast.NodeKind { return kindWikiLink }
+
+func (n *wikiLink) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Page": n.Page, "Heading": n.Heading, "Dest": n.Dest}, nil)
+}
+
+// String returns the link as written, less any label.
+func (n *wikiLink) String() string {
+	if n.Heading == "" {
+		return "[[" + n.Page + "]]"
+	}
+	return "[[" + n.Page + "#" + n.Heading + "]]"
+}
+
+type wikiLinkParser struct{}
+
+func (wikiLinkParser) Trigger() []byte { return []byte{'['} }
+
+func (wikiLinkParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	if !bytes.HasPrefix(line, []byte("[[")) {
+		return nil
+	}
+	end := bytes.Index(line[2:], []byte("]]"))
+	if end < 0 {
+		return nil
+	}
+	inner := string(line[2 : 2+end])
+	if strings.ContainsAny(inner, "[]") || strings.TrimSpace(inner) == "" || strings.EqualFold(strings.TrimSpace(inner), "toc") {
+		return nil
+	}
+
+	target, label, hasLabel := strings.Cut(inner, "|")
+	// In a table the | has to be escaped as \|.
+	target = strings.TrimSuffix(target, `\`)
+	page, heading, _ := strings.Cut(target, "#")
+	page, heading = strings.TrimSpace(page), strings.TrimSpace(heading)
+	label = strings.TrimSpace(label)
+	if !hasLabel || label == "" {
+		switch {
+		case page == "":
+			label = heading
+		case heading == "":
+			label = page
+		default:
+			label = page + " > " + heading
+		}
+	}
+
+	block.Advance(2 + end + 2)
+	n := &wikiLink{Page: page, Heading: heading}
+	n.AppendChild(n, ast.NewString([]byte(label)))
+	return n
+}
+
+// wikiPageKey holds the *wikiPage a document is parsed as.
+var wikiPageKey = parser.NewContextKey()
+
+// wikiPage is where a page being rendered sits among the pages of its tree,
+// which its [[links]] are resolved against.
+type wikiPage struct {
+	rel   string   // slash-separated path of the page
+	pages []string // every markdown page in the tree
+}
+
+// resolve returns the URL of page#heading relative to p, or "" if there's
+// no such page. Without a wikiPage, links are taken to be relative paths.
+func (p *wikiPage) resolve(page, heading string) string {
+	var dest string
+	if page != "" {
+		if p == nil {
+			if !strings.HasSuffix(strings.ToLower(page), ".md") {
+				page += ".md"
+			}
+			dest = (&url.URL{Path: page}).String()
+		} else {
+			target, ok := findWikiPage(p.pages, page, p.rel)
+			if !ok {
+				return ""
+			}
+			r, err := filepath.Rel(filepath.FromSlash(path.Dir(p.rel)), filepath.FromSlash(target))
+			if err != nil {
+				return ""
+			}
+			dest = (&url.URL{Path: filepath.ToSlash(r)}).String()
+		}
+	}
+	if heading != "" {
+		dest += "#" + githubSlug(heading)
+	}
+	return dest
+}
+
+// fingerprint identifies where p sits in its tree, which decides where
+// its [[links]] go.
+func (p *wikiPage) fingerprint() string {
+	if p == nil {
+		return "none"
+	}
+	h := fnv.New64a()
+	h.Write([]byte(p.rel))
+	for _, page := range p.pages {
+		h.Write([]byte{0})
+		h.Write([]byte(page))
+	}
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// findWikiPage returns the page among pages that the wiki link name refers
+// to from the page from: the one whose path ends with name, preferring
+// pages in from's directory, then the shallowest.
+func findWikiPage(pages []string, name, from string) (string, bool) {
+	want := strings.ToLower(strings.Trim(name, "/"))
+	want = strings.TrimSuffix(want, ".md")
+	var best string
+	for _, p := range pages {
+		key := strings.ToLower(p)
+		key = key[:len(key)-len(".md")]
+		if key != want && !strings.HasSuffix(key, "/"+want) {
+			continue
+		}
+		if best == "" || wikiPageCloser(p, best, path.Dir(from)) {
+			best = p
+		}
+	}
+	return best, best != ""
+}
+
+func wikiPageCloser(a, b, dir string) bool {
+	if inA, inB := path.Dir(a) == dir, path.Dir(b) == dir; inA != inB {
+		return inA
+	}
+	return cmp.Or(
+		cmp.Compare(strings.Count(a, "/"), strings.Count(b, "/")),
+		cmp.Compare(a, b),
+	) < 0
+}
+
+type wikiLinkResolver struct{}
+
+func (wikiLinkResolver) Transform(doc *ast.Document, _ text.Reader, pc parser.Context) {
+	at, _ := pc.Get(wikiPageKey).(*wikiPage)
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if l, ok := n.(*wikiLink); ok && entering {
+			l.Dest = at.resolve(l.Page, l.Heading)
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+type wikiLinkRenderer struct{}
+
+func (wikiLinkRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindWikiLink, func(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		l := n.(*wikiLink)
+		switch {
+		case l.Dest == "" && entering:
+			w.WriteString(`<span class="wikilink missing" title="No page named ` + html.EscapeString(l.Page) + `">`)
+		case l.Dest == "":
+			w.WriteString("</span>")
+		case entering:
+			w.WriteString(`<a class="wikilink" href="` + html.EscapeString(l.Dest) + `">`)
+		default:
+			w.WriteString("</a>")
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// markdownPages returns the slash-separated paths of the markdown files
+// under root that [[links]] may name, in lexical order: all but drafts,
+// which exports leave out.
+func markdownPages(root string) ([]string, error) {
+	var pages []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // unreadable; leave it out
+		}
+		if d.IsDir() {
+			if p != root && skippedDir(d.Name()) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(strings.ToLower(d.Name()), ".md") {
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			if content, err := os.ReadFile(p); err != nil || frontMatter(content).Draft {
+				return nil
+			}
+			pages = append(pages, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return pages, err
+}
+
+// backlink is a page that links to the one being shown.
+type backlink struct {
+	Path  string
+	URL   string
+	Title string
+	mod   time.Time
+}
+
+// wikiDoc is what wikiIndex knows about a page.
+type wikiDoc struct {
+	size  int64
+	mod   time.Time
+	title string
+	draft bool
+	wiki  []string // pages named by its [[links]]
+	links []string // markdown files its ordinary links point at
+}
+
+// wikiIndex tracks the markdown pages of the served tree and the links
+// between them, for resolving [[links]] and listing backlinks. Like
+// searchIndex it re-reads the directories the file watcher saw change, and
+// works out the links between pages again only when one of them changed.
+type wikiIndex struct {
+	mu        sync.Mutex
+	root      string // absolute directory the index describes
+	changes   *treeChanges
+	docs      map[string]*wikiDoc
+	pages     []string              // those [[links]] may name: all but drafts, in order
+	backlinks map[string][]backlink // by the page linked to
+}
+
+var siteWiki = new(wikiIndex)
+
+// page returns the page rel (slash-separated, relative to the working
+// directory) as placed in the tree, and the pages that link to it.
+func (x *wikiIndex) page(rel string) (*wikiPage, []backlink) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.refresh()
+	return &wikiPage{rel: rel, pages: x.pages}, slices.Clone(x.backlinks[rel])
+}
+
+// refresh syncs the index with the files on disk. The caller holds x.mu.
+func (x *wikiIndex) refresh() {
+	wd, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	if x.root != wd || x.docs == nil {
+		x.root = wd
+		x.changes = watchTree(wd)
+		x.docs = make(map[string]*wikiDoc)
+	}
+	changed := x.changes.take(".")
+	if len(changed) == 0 {
+		return
+	}
+	linksChanged := x.backlinks == nil
+	syncChanged(changed, slices.Collect(maps.Keys(x.docs)), func(rel string, _ fs.DirEntry) {
+		if !strings.HasSuffix(strings.ToLower(rel), ".md") {
+			return
+		}
+		info, err := os.Stat(filepath.FromSlash(rel))
+		if doc := x.docs[rel]; err == nil && doc != nil && doc.size == info.Size() && doc.mod.Equal(info.ModTime()) {
+			return
+		}
+		content, err := os.ReadFile(filepath.FromSlash(rel))
+		if err != nil {
+			delete(x.docs, rel)
+		} else {
+			x.docs[rel] = newWikiDoc(rel, content, info)
+		}
+		linksChanged = true
+	}, func(rel string) {
+		delete(x.docs, rel)
+		linksChanged = true
+	})
+	if linksChanged {
+		x.link()
+	}
+}
+
+// link works out which pages [[links]] may name and the backlinks to each
+// page. The caller holds x.mu.
+func (x *wikiIndex) link() {
+	var pages []string
+	byName := make(map[string][]string)
+	for p, doc := range x.docs {
+		if !doc.draft {
+			pages = append(pages, p)
+		}
+	}
+	slices.Sort(pages)
+	for _, p := range pages {
+		name := wikiName(p)
+		byName[name] = append(byName[name], p)
+	}
+
+	backlinks := make(map[string][]backlink)
+	for _, p := range pages {
+		doc := x.docs[p]
+		targets := make(map[string]bool)
+		for _, target := range doc.links {
+			targets[target] = true
+		}
+		for _, name := range doc.wiki {
+			if target, ok := findWikiPage(byName[wikiName(name)], name, p); ok {
+				targets[target] = true
+			}
+		}
+		delete(targets, p)
+		for target := range targets {
+			backlinks[target] = append(backlinks[target], backlink{
+				Path:  p,
+				URL:   (&url.URL{Path: "/" + p}).String(),
+				Title: doc.title,
+				mod:   doc.mod,
+			})
+		}
+	}
+	for _, links := range backlinks {
+		slices.SortFunc(links, func(a, b backlink) int {
+			return cmp.Or(cmp.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title)), cmp.Compare(a.Path, b.Path))
+		})
+	}
+	x.pages, x.backlinks = pages, backlinks
+}
+
+// wikiName returns the last part of a page's path as a wiki link names it,
+// which findWikiPage requires pages to match.
+func wikiName(p string) string {
+	return strings.TrimSuffix(path.Base(strings.ToLower(strings.Trim(p, "/"))), ".md")
+}
+
+func newWikiDoc(rel string, source []byte, info os.FileInfo) *wikiDoc {
+	doc := &wikiDoc{size: info.Size(), mod: info.ModTime()}
+	pc := parser.NewContext()
+	root := md.Parser().Parse(text.NewReader(source), parser.WithContext(pc))
+	meta := pageMetaFrom(pc)
+	doc.title, doc.draft = meta.Title, meta.Draft
+	ast.Walk(root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Heading:
+			if doc.title == "" && n.Level == 1 {
+				doc.title = headingText(n, source)
+			}
+		case *wikiLink:
+			if n.Page != "" {
+				doc.wiki = append(doc.wiki, n.Page)
+			}
+		case *ast.Link:
+			if target := linkedPage(rel, string(n.Destination)); target != "" {
+				doc.links = append(doc.links, target)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	if doc.title == "" {
+		doc.title = strings.TrimSuffix(path.Base(rel), path.Ext(rel))
+	}
+	return doc
+}
+
+// linkedPage returns the markdown file an ordinary link to dest from the
+// page rel points at, or "" if it doesn't point at one in the tree.
+func linkedPage(rel, dest string) string {
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return ""
+	}
+	var target string
+	if strings.HasPrefix(u.Path, "/") {
+		target = path.Clean(strings.TrimPrefix(u.Path, "/"))
+	} else {
+		target = path.Join(path.Dir(rel), u.Path)
+	}
+	if strings.HasSuffix(u.Path, "/") && *index != "" {
+		target = path.Join(target, *index)
+	}
+	if target == ".." || strings.HasPrefix(target, "../") || !strings.HasSuffix(strings.ToLower(target), ".md") {
+		return ""
+	}
+	return target
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWikiLinks(t *testing.T) {
+	pages := []string{"README.md", "docs/Guide.md", "docs/a.md", "notes/Guide.md", "notes/deep/Guide.md"}
+	cases := []struct{ rel, src, want string }{
+		{"docs/a.md", "[[Guide]]", `<a class="wikilink" href="Guide.md">Guide</a>`},
+		{"README.md", "[[guide.md]]", `<a class="wikilink" href="docs/Guide.md">guide.md</a>`},
+		{"README.md", "[[notes/Guide#Install Steps|setup]]", `<a class="wikilink" href="notes/Guide.md#install-steps">setup</a>`},
+		{"notes/deep/Guide.md", "[[README#Top]]", `<a class="wikilink" href="../../README.md#top">README &gt; Top</a>`},
+		{"README.md", "[[#Usage]]", `<a class="wikilink" href="#usage">Usage</a>`},
+		{"README.md", "[[Missing <Page>]]", `<span class="wikilink missing" title="No page named Missing &lt;Page&gt;">Missing &lt;Page&gt;</span>`},
+		{"README.md", "`[[Guide]]` \\[[Guide]]", `<code>[[Guide]]</code> [[Guide]]`},
+	}
+	for _, c := range cases {
+		page, err := renderMarkdownAt([]byte(c.src), &wikiPage{rel: c.rel, pages: pages})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(string(page.Content)); got != "<p>"+c.want+"</p>" {
+			t.Errorf("%s in %s:\n got %s\nwant <p>%s</p>", c.src, c.rel, got, c.want)
+		}
+	}
+
+	// [[toc]] is still the table of contents placeholder.
+	page, err := renderMarkdown([]byte("# A\n\n[[toc]]\n\n## B\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(page.Content), `<nav class="toc">`) || strings.Contains(string(page.Content), "wikilink") {
+		t.Errorf("[[toc]] taken for a wiki link: %s", page.Content)
+	}
+}
+
+func TestWikiBacklinks(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "README.md", "# Home\n\nSee [[Guide#Install]].\n", time.Time{})
+	writeFile(t, dir, "docs/Guide.md", "# Guide\n\n## Install\n", time.Time{})
+	writeFile(t, dir, "docs/a.md", "---\ntitle: Alpha\n---\nRead [the guide](Guide.md).\n", time.Time{})
+	writeFile(t, dir, "docs/b.md", "Nothing to see.\n", time.Time{})
+
+	x := new(wikiIndex)
+	_, backlinks := x.page("docs/Guide.md")
+	if len(backlinks) != 2 || backlinks[0].Title != "Alpha" || backlinks[1].Title != "Home" || backlinks[1].URL != "/README.md" {
+		t.Errorf("backlinks = %+v", backlinks)
+	}
+
+	// Changes are picked up once the watcher sees them.
+	writeFile(t, dir, "docs/a.md", "No links now.\n", time.Now().Add(time.Minute))
+	eventually(t, func() bool {
+		_, backlinks = x.page("docs/Guide.md")
+		return len(backlinks) == 1
+	})
+	if len(backlinks) != 1 {
+		t.Errorf("after edit backlinks = %+v", backlinks)
+	}
+
+	// Drafts, which exports leave out, aren't linked to or from.
+	writeFile(t, dir, "notes/Guide.md", "---\ndraft: true\n---\n# Draft guide\n", time.Time{})
+	writeFile(t, dir, "notes/c.md", "---\ndraft: true\n---\nSee [[Guide]].\n", time.Time{})
+	writeFile(t, dir, "notes/d.md", "Not a draft.\n", time.Time{})
+	var at *wikiPage
+	eventually(t, func() bool {
+		at, _ = x.page("notes/d.md")
+		return slices.Contains(at.pages, "notes/d.md")
+	})
+	if got := at.resolve("Guide", ""); got != "../docs/Guide.md" {
+		t.Errorf("[[Guide]] from notes/ = %q, want the guide that isn't a draft", got)
+	}
+	if _, backlinks := x.page("docs/Guide.md"); len(backlinks) != 1 {
+		t.Errorf("with drafts backlinks = %+v", backlinks)
+	}
+	if pages, _ := markdownPages("."); slices.Contains(pages, "notes/Guide.md") {
+		t.Errorf("markdownPages includes a draft: %v", pages)
+	}
+
+	rec := httptest.NewRecorder()
+	serveMarkdown(rec, httptest.NewRequest("GET", "/docs/Guide.md", nil), "/docs/Guide.md")
+	if body := rec.Body.String(); !strings.Contains(body, `<aside class="backlinks">`) || !strings.Contains(body, `<a href="/README.md">Home</a>`) {
+		t.Errorf("backlinks panel missing: %s", body)
+	}
+	rec = httptest.NewRecorder()
+	serveMarkdown(rec, httptest.NewRequest("GET", "/README.md", nil), "/README.md")
+	if body := rec.Body.String(); !strings.Contains(body, `<a class="wikilink" href="docs/Guide.md#install">`) {
+		t.Errorf("wiki link not resolved: %s", body)
+	}
+}
+
+func TestWikiLinksExportAndCheck(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, dir, "src/README.md", "# Home\n\n[[Guide#Install]] [[Nowhere]] [[Guide#Nope]]\n", time.Time{})
+	writeFile(t, dir, "src/docs/Guide.md", "# Guide\n\n## Install\n\nBack [[README|home]].\n", time.Time{})
+
+	if err := writeExport(context.Background(), dirExport{filepath.Join(dir, "out")}, "src"); err != nil {
+		t.Fatal(err)
+	}
+	home, _ := os.ReadFile(filepath.Join(dir, "out", "index.html"))
+	if !strings.Contains(string(home), `<a class="wikilink" href="docs/Guide.html#install">`) {
+		t.Errorf("wiki link not rewritten in export: %s", home)
+	}
+	guide, _ := os.ReadFile(filepath.Join(dir, "out", "docs", "Guide.html"))
+	if !strings.Contains(string(guide), `<a class="wikilink" href="../index.html">home</a>`) {
+		t.Errorf("wiki link to README not rewritten in export: %s", guide)
+	}
+
+	report, err := checkLinks("src", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, b := range report.Broken {
+		got = append(got, b.Target+": "+b.Reason)
+	}
+	if want := "[[Nowhere]]: no page named Nowhere\n[[Guide#Nope]]: no anchor #nope"; strings.Join(got, "\n") != want {
+		t.Errorf("broken wiki links:\n%s\nwant\n%s", strings.Join(got, "\n"), want)
+	}
+}